@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Pack writes the given paths (relative to baseDir) as a gzipped tar stream.
+// Directories are stored recursively and symlinks as links, which must
+// point inside baseDir.
+func Pack(w io.Writer, baseDir string, paths []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for _, rel := range paths {
+		if !isLocalPath(rel) {
+			return fmt.Errorf("output %q is outside the project directory", rel)
+		}
+		root := filepath.Join(baseDir, rel)
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			symlink := info.Mode()&fs.ModeSymlink != 0
+			if !info.Mode().IsRegular() && !info.IsDir() && !symlink {
+				return nil
+			}
+
+			name, err := filepath.Rel(baseDir, p)
+			if err != nil {
+				return err
+			}
+			var link string
+			if symlink {
+				if link, err = os.Readlink(p); err != nil {
+					return err
+				}
+				if !isLocalLink(filepath.ToSlash(name), filepath.ToSlash(link)) {
+					return fmt.Errorf("output %q links outside the project directory", name)
+				}
+			}
+			hdr, err := tar.FileInfoHeader(info, filepath.ToSlash(link))
+			if err != nil {
+				return err
+			}
+			hdr.Name = filepath.ToSlash(name)
+			if info.IsDir() {
+				hdr.Name += "/"
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if info.IsDir() || symlink {
+				return nil
+			}
+
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Unpack extracts a stream written by Pack into baseDir.
+func Unpack(r io.Reader, baseDir string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !isLocalPath(name) {
+			return fmt.Errorf("cache entry %q escapes the project directory", hdr.Name)
+		}
+		target := filepath.Join(baseDir, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := writeFile(target, tr, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if !isLocalLink(name, hdr.Linkname) {
+				return fmt.Errorf("cache entry %q links outside the project directory", hdr.Name)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			if err := os.Symlink(filepath.FromSlash(hdr.Linkname), target); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func writeFile(path string, r io.Reader, perm fs.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func isLocalPath(p string) bool {
+	return filepath.IsLocal(filepath.FromSlash(p))
+}
+
+// isLocalLink reports whether a symlink at name, both slash-separated and
+// relative to the base dir, points inside it.
+func isLocalLink(name, target string) bool {
+	if path.IsAbs(target) {
+		return false
+	}
+	return isLocalPath(path.Join(path.Dir(name), target))
+}
@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const DefaultMaxSize int64 = 5 << 30
+
+var ErrMiss = errors.New("cache miss")
+
+type Store interface {
+	Get(key string) (io.ReadCloser, error)
+	Put(key string, r io.Reader) error
+}
+
+type Config struct {
+	Dir      string
+	MaxSize  string
+	Remote   string
+	ReadOnly bool
+}
+
+func DefaultDir() (string, error) {
+	base, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "rem"), nil
+}
+
+// Open returns the cache described by cfg: the local cache, backed by the
+// remote one when a remote URL is configured. It returns nil when
+// REM_NO_CACHE=1.
+func Open(cfg Config) (Store, error) {
+	local, err := OpenLocal(cfg)
+	if err != nil || local == nil {
+		return nil, err
+	}
+
+	remote := strings.TrimSpace(os.Getenv("REM_CACHE_REMOTE"))
+	if remote == "" {
+		remote = cfg.Remote
+	}
+	if remote == "" {
+		return local, nil
+	}
+	if !strings.HasPrefix(remote, "http://") && !strings.HasPrefix(remote, "https://") {
+		return nil, fmt.Errorf("cache remote %q must be an http(s) URL", remote)
+	}
+
+	readOnly := cfg.ReadOnly
+	if v := strings.TrimSpace(os.Getenv("REM_CACHE_READ_ONLY")); v != "" {
+		readOnly = v == "1" || v == "true"
+	}
+	return &Tiered{
+		Local: local,
+		Remote: &HTTP{
+			URL:      remote,
+			ReadOnly: readOnly,
+			Token:    os.Getenv("REM_CACHE_TOKEN"),
+		},
+	}, nil
+}
+
+// OpenLocal returns the local cache described by cfg, with REM_CACHE_DIR and
+// REM_CACHE_MAX_SIZE taking precedence. It returns nil when REM_NO_CACHE=1.
+func OpenLocal(cfg Config) (*Local, error) {
+	if os.Getenv("REM_NO_CACHE") == "1" {
+		return nil, nil
+	}
+
+	dir := strings.TrimSpace(os.Getenv("REM_CACHE_DIR"))
+	if dir == "" {
+		dir = cfg.Dir
+	}
+	if dir == "" {
+		d, err := DefaultDir()
+		if err != nil {
+			return nil, err
+		}
+		dir = d
+	}
+
+	maxSize := DefaultMaxSize
+	sizeText := cfg.MaxSize
+	if v := strings.TrimSpace(os.Getenv("REM_CACHE_MAX_SIZE")); v != "" {
+		sizeText = v
+	}
+	if sizeText != "" {
+		n, err := ParseSize(sizeText)
+		if err != nil {
+			return nil, fmt.Errorf("cache max size: %w", err)
+		}
+		maxSize = n
+	}
+	return &Local{Dir: dir, MaxSize: maxSize}, nil
+}
+
+func ParseSize(v string) (int64, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	if v == "" {
+		return 0, fmt.Errorf("empty size")
+	}
+
+	units := []struct {
+		suffix string
+		mult   int64
+	}{
+		{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+	mult := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(v, u.suffix) {
+			mult = u.mult
+			v = strings.TrimSpace(strings.TrimSuffix(v, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return 0, fmt.Errorf("invalid size %q", v)
+	}
+	return n * mult, nil
+}
+
+func FormatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,166 @@
+package cache
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPackUnpackRoundTrip(t *testing.T) {
+	src := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(src, "bin", "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "app"), []byte("binary"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "bin", "sub", "data"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Pack(&buf, src, []string{"bin"}); err != nil {
+		t.Fatalf("Pack() error: %v", err)
+	}
+
+	dst := t.TempDir()
+	if err := Unpack(&buf, dst); err != nil {
+		t.Fatalf("Unpack() error: %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(dst, "bin", "sub", "data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "data" {
+		t.Fatalf("restored data = %q", got)
+	}
+	info, err := os.Stat(filepath.Join(dst, "bin", "app"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0o100 == 0 {
+		t.Fatalf("restored binary lost exec bit: %v", info.Mode())
+	}
+}
+
+func TestPackStoresLocalSymlinks(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "real"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("real", filepath.Join(src, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Pack(&buf, src, []string{"real", "link"}); err != nil {
+		t.Fatalf("Pack() error: %v", err)
+	}
+	dst := t.TempDir()
+	if err := Unpack(&buf, dst); err != nil {
+		t.Fatalf("Unpack() error: %v", err)
+	}
+	if target, err := os.Readlink(filepath.Join(dst, "link")); err != nil || target != "real" {
+		t.Fatalf("restored link = %q, %v", target, err)
+	}
+
+	if err := os.Symlink("/etc/passwd", filepath.Join(src, "abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../outside", filepath.Join(src, "up")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"abs", "up"} {
+		if err := Pack(io.Discard, src, []string{name}); err == nil {
+			t.Fatalf("Pack(%s) should reject a link leaving the base dir", name)
+		}
+	}
+}
+
+func TestPackRejectsPathsOutsideBase(t *testing.T) {
+	if err := Pack(io.Discard, t.TempDir(), []string{"../escape"}); err == nil {
+		t.Fatalf("expected error for output outside base dir")
+	}
+}
+
+func TestLocalPruneEvictsLeastRecentlyUsed(t *testing.T) {
+	c := &Local{Dir: t.TempDir()}
+	for _, key := range []string{"aa01", "bb02", "cc03"} {
+		if err := c.Put(key, strings.NewReader("0123456789")); err != nil {
+			t.Fatalf("Put(%s) error: %v", key, err)
+		}
+	}
+
+	old := time.Now().Add(-time.Hour)
+	for i, key := range []string{"aa01", "bb02", "cc03"} {
+		when := old.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(c.objectPath(key), when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rc, err := c.Get("aa01")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	rc.Close()
+
+	removed, freed, err := c.Prune(20)
+	if err != nil {
+		t.Fatalf("Prune() error: %v", err)
+	}
+	if removed != 1 || freed != 10 {
+		t.Fatalf("Prune() removed=%d freed=%d, want 1/10", removed, freed)
+	}
+	if _, err := c.Get("bb02"); err != ErrMiss {
+		t.Fatalf("least recently used entry should be evicted, got %v", err)
+	}
+	if _, err := c.Get("aa01"); err != nil {
+		t.Fatalf("recently used entry should survive, got %v", err)
+	}
+}
+
+func TestLocalPutKeepsEntryLargerThanMaxSize(t *testing.T) {
+	c := &Local{Dir: t.TempDir(), MaxSize: 5}
+	for _, key := range []string{"aa01", "bb02"} {
+		if err := c.Put(key, strings.NewReader("0123456789")); err != nil {
+			t.Fatalf("Put(%s) error: %v", key, err)
+		}
+		rc, err := c.Get(key)
+		if err != nil {
+			t.Fatalf("Get(%s) right after Put() error: %v", key, err)
+		}
+		rc.Close()
+	}
+	if _, err := c.Get("aa01"); err != ErrMiss {
+		t.Fatalf("older oversized entry should be evicted by the next Put, got %v", err)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	cases := map[string]int64{
+		"512":          512,
+		"10KB":         10 << 10,
+		"5G":           5 << 30,
+		"1.5GB":        -1,
+		"8388607TB":    8388607 << 40,
+		"8388608TB":    -1,
+		"9000000000TB": -1,
+	}
+	for in, want := range cases {
+		got, err := ParseSize(in)
+		if want < 0 {
+			if err == nil {
+				t.Fatalf("ParseSize(%q) expected error", in)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Fatalf("ParseSize(%q) = %d, %v; want %d", in, got, err, want)
+		}
+	}
+}
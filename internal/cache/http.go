@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type HTTP struct {
+	URL      string
+	ReadOnly bool
+	Token    string
+	Client   *http.Client
+
+	mu   sync.Mutex
+	down bool
+}
+
+func (c *HTTP) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+func (c *HTTP) url(key string) string {
+	return strings.TrimRight(c.URL, "/") + "/" + key
+}
+
+func (c *HTTP) unavailable() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.down
+}
+
+// markDown disables the remote for the rest of the run so an unreachable
+// server costs one timeout, not one per task.
+func (c *HTTP) markDown(err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = true
+	return fmt.Errorf("remote cache unavailable, continuing without it: %w", err)
+}
+
+func (c *HTTP) do(method string, key string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "rem-cli")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	return c.client().Do(req)
+}
+
+func (c *HTTP) Get(key string) (io.ReadCloser, error) {
+	if c.unavailable() {
+		return nil, ErrMiss
+	}
+
+	resp, err := c.do(http.MethodGet, key, nil)
+	if err != nil {
+		return nil, c.markDown(err)
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ErrMiss
+	case resp.StatusCode >= 500:
+		resp.Body.Close()
+		return nil, c.markDown(fmt.Errorf("GET %s: %s", c.url(key), resp.Status))
+	}
+	resp.Body.Close()
+	return nil, fmt.Errorf("GET %s: %s", c.url(key), resp.Status)
+}
+
+func (c *HTTP) Put(key string, r io.Reader) error {
+	if c.ReadOnly || c.unavailable() {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	resp, err := c.do(http.MethodPut, key, r)
+	if err != nil {
+		return c.markDown(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return c.markDown(fmt.Errorf("PUT %s: %s", c.url(key), resp.Status))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", c.url(key), resp.Status)
+	}
+	return nil
+}
+
+// Tiered reads from the local cache first and falls back to the remote,
+// keeping a local copy of remote hits. Writes go to both.
+type Tiered struct {
+	Local  *Local
+	Remote *HTTP
+}
+
+func (t *Tiered) Get(key string) (io.ReadCloser, error) {
+	rc, err := t.Local.Get(key)
+	if err == nil || !errors.Is(err, ErrMiss) {
+		return rc, err
+	}
+
+	remote, err := t.Remote.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer remote.Close()
+	if err := t.Local.Put(key, remote); err != nil {
+		return nil, err
+	}
+	return t.Local.Get(key)
+}
+
+func (t *Tiered) Put(key string, r io.Reader) error {
+	if err := t.Local.Put(key, r); err != nil {
+		return err
+	}
+	rc, err := t.Local.Get(key)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return t.Remote.Put(key, rc)
+}
+
+// Handler serves a local cache over the GET/PUT protocol used by HTTP.
+func Handler(c *Local) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		key := strings.Trim(req.URL.Path, "/")
+		if !isKey(key) {
+			http.Error(w, "invalid cache key", http.StatusBadRequest)
+			return
+		}
+
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+			rc, err := c.Get(key)
+			if errors.Is(err, ErrMiss) {
+				http.NotFound(w, req)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer rc.Close()
+			w.Header().Set("Content-Type", "application/gzip")
+			if req.Method == http.MethodGet {
+				_, _ = io.Copy(w, rc)
+			}
+		case http.MethodPut:
+			if err := c.Put(key, req.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.Header().Set("Allow", "GET, HEAD, PUT")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func isKey(key string) bool {
+	if key == "" || len(key) > 128 {
+		return false
+	}
+	for _, c := range key {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTieredUsesRemoteServedByHandler(t *testing.T) {
+	server := &Local{Dir: t.TempDir()}
+	srv := httptest.NewServer(Handler(server))
+	defer srv.Close()
+
+	writer := &Tiered{Local: &Local{Dir: t.TempDir()}, Remote: &HTTP{URL: srv.URL}}
+	if err := writer.Put("abc123", strings.NewReader("payload")); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+
+	reader := &Tiered{Local: &Local{Dir: t.TempDir()}, Remote: &HTTP{URL: srv.URL, ReadOnly: true}}
+	rc, err := reader.Get("abc123")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	got, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(got) != "payload" {
+		t.Fatalf("Get() = %q, want payload", got)
+	}
+
+	if err := reader.Put("def456", strings.NewReader("local only")); err != nil {
+		t.Fatalf("read-only Put() error: %v", err)
+	}
+	if _, err := server.Get("def456"); !errors.Is(err, ErrMiss) {
+		t.Fatalf("read-only cache must not upload, got %v", err)
+	}
+}
+
+func TestTieredUploadsEntryLargerThanLocalMaxSize(t *testing.T) {
+	server := &Local{Dir: t.TempDir()}
+	srv := httptest.NewServer(Handler(server))
+	defer srv.Close()
+
+	c := &Tiered{Local: &Local{Dir: t.TempDir(), MaxSize: 1}, Remote: &HTTP{URL: srv.URL}}
+	if err := c.Put("abc123", strings.NewReader("payload")); err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	rc, err := server.Get("abc123")
+	if err != nil {
+		t.Fatalf("entry was not uploaded: %v", err)
+	}
+	rc.Close()
+}
+
+func TestHTTPDegradesWhenUnreachable(t *testing.T) {
+	srv := httptest.NewServer(Handler(&Local{Dir: t.TempDir()}))
+	url := srv.URL
+	srv.Close()
+
+	c := &HTTP{URL: url}
+	if _, err := c.Get("abc123"); err == nil || errors.Is(err, ErrMiss) {
+		t.Fatalf("first Get() should report the outage, got %v", err)
+	}
+	if _, err := c.Get("abc123"); !errors.Is(err, ErrMiss) {
+		t.Fatalf("later Get() should be a silent miss, got %v", err)
+	}
+	if err := c.Put("abc123", strings.NewReader("x")); err != nil {
+		t.Fatalf("Put() after outage should be a no-op, got %v", err)
+	}
+}
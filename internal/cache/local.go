@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const objectSuffix = ".tar.gz"
+
+type Local struct {
+	Dir     string
+	MaxSize int64
+
+	mu sync.Mutex
+}
+
+type Entry struct {
+	Key  string
+	Size int64
+	Used time.Time
+	path string
+}
+
+type Stats struct {
+	Dir     string
+	Entries int
+	Size    int64
+	MaxSize int64
+}
+
+func (c *Local) objectPath(key string) string {
+	prefix := key
+	if len(prefix) > 2 {
+		prefix = prefix[:2]
+	}
+	return filepath.Join(c.Dir, "objects", prefix, key+objectSuffix)
+}
+
+func (c *Local) Get(key string) (io.ReadCloser, error) {
+	path := c.objectPath(key)
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrMiss
+		}
+		return nil, err
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return f, nil
+}
+
+func (c *Local) Put(key string, r io.Reader) error {
+	path := c.objectPath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if c.MaxSize > 0 {
+		// The entry just written stays even when it alone exceeds MaxSize:
+		// the caller is about to read it back, and the next Put evicts it.
+		_, _, err := c.prune(c.MaxSize, key)
+		return err
+	}
+	return nil
+}
+
+func (c *Local) Entries() ([]Entry, error) {
+	root := filepath.Join(c.Dir, "objects")
+	entries := make([]Entry, 0, 64)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, objectSuffix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		entries = append(entries, Entry{
+			Key:  strings.TrimSuffix(d.Name(), objectSuffix),
+			Size: info.Size(),
+			Used: info.ModTime(),
+			path: p,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Used.After(entries[j].Used)
+	})
+	return entries, nil
+}
+
+func (c *Local) Stats() (Stats, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return Stats{}, err
+	}
+	st := Stats{Dir: c.Dir, Entries: len(entries), MaxSize: c.MaxSize}
+	for _, e := range entries {
+		st.Size += e.Size
+	}
+	return st, nil
+}
+
+// Prune evicts least recently used entries until the cache fits in maxSize.
+// A maxSize of 0 empties the cache.
+func (c *Local) Prune(maxSize int64) (removed int, freed int64, err error) {
+	return c.prune(maxSize, "")
+}
+
+// prune is Prune sparing the entry for keep.
+func (c *Local) prune(maxSize int64, keep string) (removed int, freed int64, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := c.Entries()
+	if err != nil {
+		return 0, 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	for i := len(entries) - 1; i >= 0 && total > maxSize; i-- {
+		e := entries[i]
+		if e.Key == keep {
+			continue
+		}
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return removed, freed, err
+		}
+		total -= e.Size
+		freed += e.Size
+		removed++
+	}
+	return removed, freed, nil
+}
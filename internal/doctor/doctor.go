@@ -2,7 +2,9 @@ package doctor
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -22,14 +24,41 @@ const (
 	SeverityFail
 )
 
+func (s Severity) String() string {
+	switch s {
+	case SeverityWarn:
+		return "warn"
+	case SeverityFail:
+		return "fail"
+	}
+	return "ok"
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
 type Check struct {
-	Severity Severity
-	Name     string
-	Detail   string
+	Severity Severity `json:"severity"`
+	Name     string   `json:"name"`
+	Detail   string   `json:"detail"`
 }
 
+// Report is the result of `rem doctor`. Diagnostics repeats the Remfile's
+// errors and warnings with their positions for `rem doctor --json`.
 type Report struct {
-	Checks []Check
+	Checks      []Check             `json:"checks"`
+	Diagnostics remfile.Diagnostics `json:"diagnostics"`
+}
+
+// WriteJSON writes the report as printed by `rem doctor --json`.
+func (r Report) WriteJSON(w io.Writer) error {
+	if r.Diagnostics == nil {
+		r.Diagnostics = remfile.Diagnostics{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
 }
 
 func (r Report) Counts() (ok int, warn int, fail int) {
@@ -74,7 +103,9 @@ func Run(remVersion string, remfilePath string, defaultUpdateRepo string) Report
 	out.Checks = append(out.Checks, checkToolVersion("git", "git", "--version"))
 	out.Checks = append(out.Checks, checkShell())
 
-	out.Checks = append(out.Checks, checkRemfile(remfilePath))
+	remfileChecks, diags := checkRemfile(remfilePath)
+	out.Checks = append(out.Checks, remfileChecks...)
+	out.Diagnostics = diags
 	out.Checks = append(out.Checks, checkUpdateRepo(defaultUpdateRepo))
 
 	return out
@@ -112,7 +143,9 @@ func checkToolVersion(name string, cmd string, args ...string) Check {
 	}
 }
 
-func checkRemfile(path string) Check {
+// checkRemfile reports the Remfile as one check, followed by one check per
+// error or warning found in it.
+func checkRemfile(path string) ([]Check, remfile.Diagnostics) {
 	absPath, err := filepath.Abs(path)
 	if err != nil {
 		absPath = path
@@ -120,32 +153,58 @@ func checkRemfile(path string) Check {
 
 	if _, err := os.Stat(path); err != nil {
 		if os.IsNotExist(err) {
-			return Check{
+			return []Check{{
 				Severity: SeverityWarn,
 				Name:     "remfile",
 				Detail:   fmt.Sprintf("%s does not exist", absPath),
-			}
+			}}, nil
 		}
-		return Check{
+		return []Check{{
 			Severity: SeverityFail,
 			Name:     "remfile",
 			Detail:   fmt.Sprintf("stat failed: %v", err),
-		}
+		}}, nil
 	}
 
-	rf, err := remfile.Load(path)
+	rf, diags, err := remfile.Diagnose(path)
 	if err != nil {
-		return Check{
+		return []Check{{
 			Severity: SeverityFail,
 			Name:     "remfile",
-			Detail:   fmt.Sprintf("parse failed: %v", err),
-		}
+			Detail:   fmt.Sprintf("read failed: %v", err),
+		}}, nil
 	}
-	return Check{
-		Severity: SeverityOK,
-		Name:     "remfile",
-		Detail:   fmt.Sprintf("%s parsed: tasks=%d default=%s", absPath, len(rf.Order), rf.DefaultTarget()),
+
+	errs, warns := diags.Count(remfile.SeverityError), diags.Count(remfile.SeverityWarning)
+	checks := make([]Check, 0, len(diags)+1)
+	switch {
+	case rf == nil:
+		checks = append(checks, Check{
+			Severity: SeverityFail,
+			Name:     "remfile",
+			Detail:   fmt.Sprintf("%s: %d error(s), %d warning(s)", absPath, errs, warns),
+		})
+	case warns > 0:
+		checks = append(checks, Check{
+			Severity: SeverityWarn,
+			Name:     "remfile",
+			Detail:   fmt.Sprintf("%s parsed with %d warning(s): tasks=%d default=%s", absPath, warns, len(rf.Order), rf.DefaultTarget()),
+		})
+	default:
+		checks = append(checks, Check{
+			Severity: SeverityOK,
+			Name:     "remfile",
+			Detail:   fmt.Sprintf("%s parsed: tasks=%d default=%s", absPath, len(rf.Order), rf.DefaultTarget()),
+		})
+	}
+	for _, d := range diags {
+		sev := SeverityFail
+		if d.Severity == remfile.SeverityWarning {
+			sev = SeverityWarn
+		}
+		checks = append(checks, Check{Severity: sev, Name: "remfile", Detail: d.Error()})
 	}
+	return checks, diags
 }
 
 func checkUpdateRepo(defaultRepo string) Check {
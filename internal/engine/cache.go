@@ -0,0 +1,103 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"rem/internal/cache"
+	"rem/internal/remfile"
+	"rem/internal/shellcfg"
+)
+
+// cachePlatform is the platform outputs were built for.
+var cachePlatform = runtime.GOOS + "/" + runtime.GOARCH
+
+// cacheKey names fp's outputs in the cache. The fingerprint alone decides
+// whether a task is up to date locally, but a shared remote cache is warmed
+// by other machines, so the key also holds the platform and the task shell.
+func cacheKey(fp *fingerprint) string {
+	bin, _, _ := shellcfg.ResolveTaskShell()
+	shell := strings.TrimSuffix(strings.ToLower(filepath.Base(bin)), ".exe")
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s", fp.sum, cachePlatform, shell)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (r *Runner) cacheable(fp *fingerprint) bool {
+	// Inputs discovered through a depfile are not part of the fingerprint,
+	// so the fingerprint alone cannot identify the outputs.
+	if r.Cache == nil || fp == nil || fp.inputs == nil || fp.depfile != "" {
+		return false
+	}
+	for _, out := range fp.outputs {
+		if !filepath.IsLocal(strings.TrimPrefix(out, "!")) {
+			return false
+		}
+	}
+	return true
+}
+
+// restoreOutputs unpacks the cached outputs of task. An entry that does not
+// bring back every declared output, such as a link to a file left out of
+// it, counts as a miss.
+func (r *Runner) restoreOutputs(task *remfile.Task, fp *fingerprint) bool {
+	taskName := task.Name
+	if !r.cacheable(fp) {
+		return false
+	}
+
+	rc, err := r.Cache.Get(cacheKey(fp))
+	if err != nil {
+		if !errors.Is(err, cache.ErrMiss) {
+			r.cacheWarning(taskName, err)
+		}
+		return false
+	}
+	defer rc.Close()
+
+	if err := cache.Unpack(rc, r.File.Dir); err != nil {
+		r.cacheWarning(taskName, err)
+		return false
+	}
+	outputs, err := r.resolveOutputs(task)
+	if err != nil {
+		r.cacheWarning(taskName, err)
+		return false
+	}
+	return len(outputs.missing) == 0
+}
+
+func (r *Runner) storeOutputs(task *remfile.Task, fp *fingerprint) {
+	taskName := task.Name
+	if !r.cacheable(fp) {
+		return
+	}
+	outputs, err := r.resolveOutputs(task)
+	if err != nil || len(outputs.missing) > 0 {
+		return
+	}
+	files := make([]string, 0, len(outputs.files))
+	for _, f := range outputs.files {
+		files = append(files, filepath.FromSlash(relPath(r.File.Dir, f)))
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(cache.Pack(pw, r.File.Dir, files))
+	}()
+	err = r.Cache.Put(cacheKey(fp), pr)
+	pr.CloseWithError(err)
+	if err != nil {
+		r.cacheWarning(taskName, err)
+	}
+}
+
+func (r *Runner) cacheWarning(taskName string, err error) {
+	fmt.Fprintf(r.Stderr, "%s %s: %v\n", r.paint("33", "[cache]"), taskName, err)
+}
@@ -0,0 +1,173 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"rem/internal/remfile"
+)
+
+// parseDepfile returns the prerequisites listed in a Makefile-style
+// depfile such as the ones written by `gcc -MD`. Targets are dropped; the
+// prerequisites of every rule are collected in order without duplicates.
+func parseDepfile(data []byte) ([]string, error) {
+	var (
+		deps      []string
+		seen      = make(map[string]bool)
+		tok       []byte
+		hasTok    bool
+		sawTarget bool
+		inDeps    bool
+		line      = 1
+	)
+	flush := func() {
+		if !hasTok {
+			return
+		}
+		name := string(tok)
+		tok, hasTok = tok[:0], false
+		if !inDeps {
+			sawTarget = true
+			return
+		}
+		if !seen[name] {
+			seen[name] = true
+			deps = append(deps, name)
+		}
+	}
+	endRule := func() error {
+		flush()
+		if sawTarget && !inDeps {
+			return fmt.Errorf("line %d: expected ':' after target", line)
+		}
+		sawTarget, inDeps = false, false
+		return nil
+	}
+	isSpace := func(i int) bool {
+		return i >= len(data) || data[i] == ' ' || data[i] == '\t' || data[i] == '\n' || data[i] == '\r'
+	}
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case c == '\\' && i+1 < len(data) && (data[i+1] == '\n' || data[i+1] == '\r'):
+			flush()
+			i++
+			if data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n' {
+				i++
+			}
+			line++
+		case c == '\\' && i+1 < len(data) && (data[i+1] == ' ' || data[i+1] == '#'):
+			i++
+			tok, hasTok = append(tok, data[i]), true
+		case c == '$' && i+1 < len(data) && data[i+1] == '$':
+			i++
+			tok, hasTok = append(tok, '$'), true
+		case c == '#' && !hasTok:
+			for i+1 < len(data) && data[i+1] != '\n' {
+				i++
+			}
+		case c == '\n':
+			if err := endRule(); err != nil {
+				return nil, err
+			}
+			line++
+		case c == ' ' || c == '\t' || c == '\r':
+			flush()
+		case c == ':' && isSpace(i+1):
+			flush()
+			if inDeps {
+				return nil, fmt.Errorf("line %d: unexpected ':'", line)
+			}
+			if !sawTarget {
+				return nil, fmt.Errorf("line %d: missing target before ':'", line)
+			}
+			inDeps = true
+		default:
+			tok, hasTok = append(tok, c), true
+		}
+	}
+	if err := endRule(); err != nil {
+		return nil, err
+	}
+	return deps, nil
+}
+
+// discoverInputs reads the depfile a task wrote and records the files it
+// lists, keyed like inputs. Paths in the depfile are relative to the task
+// dir, where the compiler ran. In mtime mode only the paths are kept.
+func (r *Runner) discoverInputs(t *remfile.Task, depfile string) (map[string]string, error) {
+	if !filepath.IsAbs(depfile) {
+		depfile = filepath.Join(r.File.TaskFile(t).Dir, depfile)
+	}
+	data, err := os.ReadFile(depfile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("depfile %s was not written", relPath(r.File.Dir, depfile))
+		}
+		return nil, err
+	}
+	deps, err := parseDepfile(data)
+	if err != nil {
+		return nil, fmt.Errorf("depfile %s: %w", relPath(r.File.Dir, depfile), err)
+	}
+
+	dir := r.taskDir(t)
+	discovered := make(map[string]string, len(deps))
+	for _, dep := range deps {
+		full := dep
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(dir, dep)
+		}
+		sum := ""
+		if t.Check != remfile.CheckMtime {
+			if sum, err = hashFile(full); err != nil {
+				if !os.IsNotExist(err) {
+					return nil, err
+				}
+				sum = "missing"
+			}
+		}
+		discovered[relPath(r.File.Dir, full)] = sum
+	}
+	return discovered, nil
+}
+
+// discoveredChange reports which input found through the depfile of the
+// last run changed since then, or "" when none did.
+func (r *Runner) discoveredChange(rec *taskRecord, hashInputs bool, oldestOutput time.Time) (string, error) {
+	for _, k := range sortedKeys(rec.Discovered) {
+		full := filepath.FromSlash(k)
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(r.File.Dir, full)
+		}
+
+		if hashInputs {
+			sum, err := hashFile(full)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return "", err
+				}
+				sum = "missing"
+			}
+			if sum != rec.Discovered[k] {
+				return "discovered input " + k + " changed", nil
+			}
+			continue
+		}
+
+		info, err := os.Stat(full)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return "discovered input " + k + " removed", nil
+			}
+			return "", err
+		}
+		if info.ModTime().After(oldestOutput) {
+			return "discovered input " + k + " newer than output", nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,26 @@
+package engine
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseDepfile(t *testing.T) {
+	data := "build/foo.o: src/foo.c include/foo.h \\\n  include/my\\ dir/bar.h lib$$x.h\n" +
+		"# generated by gcc -MP\n" +
+		"include/foo.h:\n" +
+		"build/bar.o build/baz.o: src/foo.c \\\r\n src/bar.c\n"
+
+	deps, err := parseDepfile([]byte(data))
+	if err != nil {
+		t.Fatalf("parseDepfile() error: %v", err)
+	}
+	want := []string{"src/foo.c", "include/foo.h", "include/my dir/bar.h", "lib$x.h", "src/bar.c"}
+	if !slices.Equal(deps, want) {
+		t.Fatalf("deps = %q, want %q", deps, want)
+	}
+
+	if _, err := parseDepfile([]byte("build/foo.o src/foo.c\n")); err == nil {
+		t.Fatalf("expected error for rule without ':'")
+	}
+}
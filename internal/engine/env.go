@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"os"
+	"runtime"
+	"strings"
+
+	"rem/internal/remfile"
+)
+
+// defaultKeptEnv survives clean_env so shells and toolchains keep working.
+var defaultKeptEnv = []string{"PATH", "HOME", "USER", "TMPDIR", "TEMP", "TMP", "SYSTEMROOT", "COMSPEC", "PATHEXT"}
+
+// taskEnv builds the environment for a task's commands: the process
+// environment (only its allow-listed part with clean_env), then dotenv
+// values it does not already define, overlaid with the Remfile's `[env]`
+// table and the task's `env`. clean_env and keep_env come from the Remfile
+// that defines the task.
+func (r *Runner) taskEnv(t *remfile.Task) []string {
+	scope := r.File.TaskFile(t)
+	base := os.Environ()
+	if scope.CleanEnv {
+		keep := append(append([]string(nil), defaultKeptEnv...), scope.KeepEnv...)
+		kept := base[:0:0]
+		for _, kv := range base {
+			name, _, _ := strings.Cut(kv, "=")
+			if keepEnv(keep, name) {
+				kept = append(kept, kv)
+			}
+		}
+		base = kept
+	}
+
+	env := make([]string, 0, len(base)+len(scope.Env)+len(t.Env))
+	index := make(map[string]int, cap(env))
+	set := func(name, kv string) {
+		key := envKey(name)
+		if i, ok := index[key]; ok {
+			env[i] = kv
+			return
+		}
+		index[key] = len(env)
+		env = append(env, kv)
+	}
+	for _, kv := range base {
+		name, _, _ := strings.Cut(kv, "=")
+		set(name, kv)
+	}
+	for _, e := range r.File.TaskDotenv(t) {
+		if _, ok := index[envKey(e.Name)]; !ok {
+			set(e.Name, e.Name+"="+e.Value)
+		}
+	}
+	for _, e := range r.File.TaskEnv(t) {
+		set(e.Name, e.Name+"="+e.Value)
+	}
+	return env
+}
+
+// keepEnv matches name against allow-list entries; a trailing `*` matches
+// a prefix.
+func keepEnv(keep []string, name string) bool {
+	for _, k := range keep {
+		if prefix, ok := strings.CutSuffix(k, "*"); ok {
+			if strings.HasPrefix(envKey(name), envKey(prefix)) {
+				return true
+			}
+			continue
+		}
+		if envKey(name) == envKey(k) {
+			return true
+		}
+	}
+	return false
+}
+
+func envKey(name string) string {
+	if runtime.GOOS == "windows" {
+		return strings.ToUpper(name)
+	}
+	return name
+}
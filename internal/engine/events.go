@@ -0,0 +1,96 @@
+package engine
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	EventRunStarted     EventType = "run_started"
+	EventTaskQueued     EventType = "task_queued"
+	EventTaskSkipped    EventType = "task_skipped"
+	EventCommandStarted EventType = "command_started"
+	EventCommandExited  EventType = "command_exited"
+	EventTaskFinished   EventType = "task_finished"
+	EventRunFinished    EventType = "run_finished"
+)
+
+const (
+	StatusOK       = "ok"
+	StatusFailed   = "failed"
+	StatusSkipped  = "skipped"
+	StatusCached   = "cached"
+	StatusBlocked  = "blocked"
+	StatusCanceled = "canceled"
+)
+
+type Event struct {
+	Type       EventType `json:"type"`
+	Time       time.Time `json:"time"`
+	Target     string    `json:"target,omitempty"`
+	Targets    []string  `json:"targets,omitempty"`
+	Tasks      []string  `json:"tasks,omitempty"`
+	Task       string    `json:"task,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Dir        string    `json:"dir,omitempty"`
+	Attempt    int       `json:"attempt,omitempty"`
+	ExitCode   *int      `json:"exit_code,omitempty"`
+	DurationMS *int64    `json:"duration_ms,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// EventSink receives run events. Tasks run in parallel, so implementations
+// must be safe for concurrent use.
+type EventSink interface {
+	Event(Event)
+}
+
+type JSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w)}
+}
+
+func (s *JSONSink) Event(ev Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(ev)
+}
+
+// OpenJSONEvents resolves an --events value: "json" or "-" writes to stdout,
+// anything else is treated as a file path. The returned func closes the file.
+func OpenJSONEvents(spec string, stdout io.Writer) (*JSONSink, func() error, error) {
+	if spec == "json" || spec == "-" {
+		return NewJSONSink(stdout), func() error { return nil }, nil
+	}
+	f, err := os.Create(spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewJSONSink(f), f.Close, nil
+}
+
+func (r *Runner) emit(ev Event) {
+	if r.Events == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	r.Events.Event(ev)
+}
+
+func durationMS(start time.Time) *int64 {
+	ms := time.Since(start).Milliseconds()
+	return &ms
+}
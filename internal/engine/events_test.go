@@ -0,0 +1,62 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"rem/internal/remfile"
+)
+
+func TestJSONEventsDescribeRun(t *testing.T) {
+	rf := &remfile.File{
+		Dir:     t.TempDir(),
+		Default: "build",
+		Order:   []string{"gen", "build"},
+		Tasks: map[string]*remfile.Task{
+			"gen":   {Name: "gen", Cmds: []string{"exit 0"}},
+			"build": {Name: "build", Deps: []string{"gen"}, Cmds: []string{"exit 3"}},
+		},
+	}
+
+	var buf bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard, Events: NewJSONSink(&buf)}
+	if err := r.Run("build"); err == nil {
+		t.Fatalf("expected build to fail")
+	}
+
+	var events []Event
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var ev Event
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event line %q: %v", sc.Text(), err)
+		}
+		events = append(events, ev)
+	}
+
+	want := []EventType{
+		EventRunStarted,
+		EventTaskQueued, EventCommandStarted, EventCommandExited, EventTaskFinished,
+		EventTaskQueued, EventCommandStarted, EventCommandExited, EventTaskFinished,
+		EventRunFinished,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %#v", len(events), len(want), events)
+	}
+	for i, ev := range events {
+		if ev.Type != want[i] {
+			t.Fatalf("event %d = %s, want %s", i, ev.Type, want[i])
+		}
+	}
+
+	exited := events[7]
+	if exited.Task != "build" || exited.ExitCode == nil || *exited.ExitCode != 3 {
+		t.Fatalf("unexpected command_exited event: %#v", exited)
+	}
+	if events[8].Status != StatusFailed || events[9].Status != StatusFailed {
+		t.Fatalf("expected failed task and run, got %q / %q", events[8].Status, events[9].Status)
+	}
+}
@@ -0,0 +1,248 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"rem/internal/remfile"
+)
+
+type ExplainedFile struct {
+	Pattern string
+	Path    string
+	ModTime time.Time
+	Hash    string
+	Missing bool
+	Changed bool
+}
+
+type Explanation struct {
+	Task       string
+	Target     string
+	Chain      []string
+	Check      string
+	UpToDate   bool
+	Reason     string
+	Trigger    string
+	LastRun    time.Time
+	Inputs     []ExplainedFile
+	Outputs    []ExplainedFile
+	EmptyGlobs []string
+}
+
+func (r *Runner) ExplainTask(taskName, target string) (*Explanation, error) {
+	if r.File == nil {
+		return nil, fmt.Errorf("runner has no loaded Remfile")
+	}
+	if target == "" {
+		target = r.File.DefaultTarget()
+	}
+	target = r.File.ExpandString(target)
+	taskName = r.File.ExpandString(taskName)
+	task, ok := r.File.Tasks[taskName]
+	if !ok {
+		return nil, r.File.NoSuchTask("task", taskName)
+	}
+	if _, ok := r.File.Tasks[target]; !ok {
+		return nil, r.File.NoSuchTask("target", target)
+	}
+	if _, err := r.collectSubset(target); err != nil {
+		return nil, err
+	}
+	chain := r.dependencyChain(target, taskName)
+	if chain == nil {
+		return nil, fmt.Errorf("task %q is not part of target %q", taskName, target)
+	}
+	if r.state == nil {
+		st, err := loadState(r.File.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("load state: %w", err)
+		}
+		r.state = st
+	}
+
+	ex := &Explanation{
+		Task:   taskName,
+		Target: target,
+		Chain:  chain,
+		Check:  task.Check,
+	}
+	if ex.Check == "" {
+		ex.Check = remfile.CheckHash
+	}
+
+	upToDate, reason, _, err := r.isUpToDate(task)
+	if err != nil {
+		return nil, err
+	}
+	ex.UpToDate = upToDate
+	ex.Reason = reason
+
+	rec := r.state.get(taskName)
+	if rec != nil {
+		ex.LastRun = rec.Time
+	}
+
+	outputs, err := r.resolveOutputs(task)
+	if err != nil {
+		return nil, err
+	}
+	oldestOutput := outputs.oldest
+	for _, out := range outputs.missing {
+		ex.Outputs = append(ex.Outputs, ExplainedFile{Pattern: out, Path: out, Missing: true})
+		if ex.Trigger == "" {
+			ex.Trigger = out
+		}
+	}
+	for _, file := range outputs.files {
+		f := ExplainedFile{Path: relPath(r.File.Dir, file)}
+		if info, err := os.Stat(file); err == nil {
+			f.ModTime = info.ModTime()
+		}
+		ex.Outputs = append(ex.Outputs, f)
+	}
+
+	files, empty, err := r.resolvePatterns(r.File.TaskFile(task).Dir, r.File.ExpandTaskList(task, task.Inputs))
+	if err != nil {
+		return nil, err
+	}
+	ex.EmptyGlobs = empty
+
+	newestChanged := ExplainedFile{}
+	for _, file := range files {
+		f := ExplainedFile{Pattern: file.Pattern, Path: relPath(r.File.Dir, file.Path)}
+		info, err := os.Stat(file.Path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			f.Missing = true
+		} else {
+			f.ModTime = info.ModTime()
+		}
+
+		if ex.Check == remfile.CheckMtime {
+			f.Changed = !f.Missing && !oldestOutput.IsZero() && f.ModTime.After(oldestOutput)
+			if f.Changed && f.ModTime.After(newestChanged.ModTime) {
+				newestChanged = f
+			}
+		} else {
+			if !f.Missing {
+				if f.Hash, err = hashFile(file.Path); err != nil {
+					return nil, err
+				}
+			}
+			if rec != nil {
+				old, ok := rec.Inputs[f.Path]
+				f.Changed = !ok || (f.Missing && old != "missing") || (!f.Missing && old != f.Hash)
+				if f.Changed && ex.Trigger == "" {
+					ex.Trigger = f.Path
+				}
+			}
+		}
+		ex.Inputs = append(ex.Inputs, f)
+	}
+	if ex.Trigger == "" && newestChanged.Path != "" {
+		ex.Trigger = newestChanged.Path
+	}
+	if ex.UpToDate {
+		ex.Trigger = ""
+	}
+	return ex, nil
+}
+
+// dependencyChain returns the shortest dependency path from target to task,
+// or nil when task is not reachable from target.
+func (r *Runner) dependencyChain(target, taskName string) []string {
+	prev := map[string]string{target: ""}
+	queue := []string{target}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if name == taskName {
+			chain := []string{}
+			for n := name; n != ""; n = prev[n] {
+				chain = append([]string{n}, chain...)
+			}
+			return chain
+		}
+		for _, dep := range r.File.ExpandTaskList(r.File.Tasks[name], r.File.Tasks[name].Deps) {
+			if _, seen := prev[dep]; !seen {
+				prev[dep] = name
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Runner) Explain(taskName, target string) error {
+	ex, err := r.ExplainTask(taskName, target)
+	if err != nil {
+		return err
+	}
+	if r.Stdout == nil {
+		r.Stdout = os.Stdout
+	}
+	w := r.Stdout
+
+	verdict := r.paint("34", "will run")
+	if ex.UpToDate {
+		verdict = r.paint("33", "will skip")
+	}
+	fmt.Fprintf(w, "%s %s (%s)\n", ex.Task, verdict, ex.Reason)
+	if ex.Trigger != "" {
+		fmt.Fprintf(w, "  triggered by: %s\n", ex.Trigger)
+	}
+	fmt.Fprintf(w, "  check: %s\n", ex.Check)
+	if !ex.LastRun.IsZero() {
+		fmt.Fprintf(w, "  last run: %s\n", ex.LastRun.Local().Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "  chain: %s\n", strings.Join(ex.Chain, " -> "))
+
+	fmt.Fprintln(w, "  inputs:")
+	if len(ex.Inputs) == 0 {
+		fmt.Fprintln(w, "    (none)")
+	}
+	for _, f := range ex.Inputs {
+		fmt.Fprintf(w, "    %s %s\n", r.fileMark(f), describeFile(f))
+	}
+	for _, g := range ex.EmptyGlobs {
+		fmt.Fprintf(w, "    %s %s (matched nothing)\n", r.paint("33", "?"), g)
+	}
+
+	fmt.Fprintln(w, "  outputs:")
+	if len(ex.Outputs) == 0 {
+		fmt.Fprintln(w, "    (none, task always runs)")
+	}
+	for _, f := range ex.Outputs {
+		fmt.Fprintf(w, "    %s %s\n", r.fileMark(f), describeFile(f))
+	}
+	return nil
+}
+
+func (r *Runner) fileMark(f ExplainedFile) string {
+	switch {
+	case f.Missing:
+		return r.paint("31", "!")
+	case f.Changed:
+		return r.paint("34", "*")
+	}
+	return " "
+}
+
+func describeFile(f ExplainedFile) string {
+	if f.Missing {
+		return f.Path + " (missing)"
+	}
+	desc := f.Path + " mtime=" + f.ModTime.Local().Format(time.RFC3339)
+	if f.Hash != "" {
+		desc += " sha256=" + f.Hash[:12]
+	}
+	if f.Changed {
+		desc += " (changed)"
+	}
+	return desc
+}
@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rem/internal/remfile"
+)
+
+func TestExplainReportsTriggerChainAndEmptyGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "all",
+		Order:   []string{"build", "all"},
+		Tasks: map[string]*remfile.Task{
+			"build": {
+				Name:    "build",
+				Inputs:  []string{"*.txt", "gen/*.go"},
+				Outputs: []string{"out.bin"},
+				Cmds:    []string{"cat a.txt b.txt > out.bin"},
+			},
+			"all": {Name: "all", Deps: []string{"build"}},
+		},
+	}
+
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	if err := r.Run("all"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.txt"), []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	r = &Runner{File: rf, Stdout: &out}
+	ex, err := r.ExplainTask("build", "all")
+	if err != nil {
+		t.Fatalf("ExplainTask() error: %v", err)
+	}
+	if ex.UpToDate || ex.Trigger != "b.txt" {
+		t.Fatalf("upToDate=%v trigger=%q, want rebuild triggered by b.txt", ex.UpToDate, ex.Trigger)
+	}
+	if strings.Join(ex.Chain, " -> ") != "all -> build" {
+		t.Fatalf("chain = %v", ex.Chain)
+	}
+	if len(ex.EmptyGlobs) != 1 || ex.EmptyGlobs[0] != "gen/*.go" {
+		t.Fatalf("empty globs = %v", ex.EmptyGlobs)
+	}
+
+	if err := r.Explain("build", "all"); err != nil {
+		t.Fatalf("Explain() error: %v", err)
+	}
+	if !strings.Contains(out.String(), "triggered by: b.txt") {
+		t.Fatalf("explain output missing trigger:\n%s", out.String())
+	}
+
+	if _, err := r.ExplainTask("all", "build"); err == nil {
+		t.Fatalf("expected error for task outside target subset")
+	}
+}
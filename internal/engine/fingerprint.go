@@ -0,0 +1,255 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"rem/internal/remfile"
+)
+
+type fingerprint struct {
+	sum     string
+	inputs  map[string]string
+	cmds    []string
+	dir     string
+	outputs []string
+	depfile string
+	vars    map[string]string
+	env     map[string]string
+}
+
+func (r *Runner) fingerprintTask(t *remfile.Task, hashInputs bool) (*fingerprint, error) {
+	fp := &fingerprint{
+		dir:     r.File.ExpandTask(t, t.Dir),
+		outputs: r.File.ExpandTaskList(t, t.Outputs),
+		depfile: r.File.ExpandTask(t, t.Depfile),
+		vars:    r.taskVars(t),
+		env:     make(map[string]string),
+	}
+	// Expanded commands, vars and env values end up in .rem/state, so only
+	// their hashes are kept.
+	for _, cmd := range r.taskCommands(t) {
+		fp.cmds = append(fp.cmds, hashValue(cmd))
+	}
+	for name, val := range fp.vars {
+		fp.vars[name] = hashValue(val)
+	}
+	for _, e := range append(r.File.TaskDotenv(t), r.File.TaskEnv(t)...) {
+		fp.env[e.Name] = hashValue(e.Value)
+	}
+
+	if hashInputs {
+		files, _, err := r.resolvePatterns(r.File.TaskFile(t).Dir, r.File.ExpandTaskList(t, t.Inputs))
+		if err != nil {
+			return nil, err
+		}
+		fp.inputs = make(map[string]string, len(files))
+		for _, f := range files {
+			sum, err := hashFile(f.Path)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					return nil, err
+				}
+				sum = "missing"
+			}
+			fp.inputs[relPath(r.File.Dir, f.Path)] = sum
+		}
+	}
+
+	h := sha256.New()
+	for _, k := range sortedKeys(fp.inputs) {
+		fmt.Fprintf(h, "in %q %s\n", k, fp.inputs[k])
+	}
+	for _, out := range fp.outputs {
+		fmt.Fprintf(h, "out %q\n", out)
+	}
+	for _, cmd := range fp.cmds {
+		fmt.Fprintf(h, "cmd %s\n", cmd)
+	}
+	fmt.Fprintf(h, "dir %q\n", fp.dir)
+	if fp.depfile != "" {
+		fmt.Fprintf(h, "depfile %q\n", fp.depfile)
+	}
+	for _, k := range sortedKeys(fp.vars) {
+		fmt.Fprintf(h, "var %s=%q\n", k, fp.vars[k])
+	}
+	for _, k := range sortedKeys(fp.env) {
+		fmt.Fprintf(h, "env %s=%q\n", k, fp.env[k])
+	}
+	fp.sum = hex.EncodeToString(h.Sum(nil))
+	return fp, nil
+}
+
+// taskVars returns the values of every ${VAR} the task references,
+// directly or through other vars.
+func (r *Runner) taskVars(t *remfile.Task) map[string]string {
+	values := make([]string, 0, len(t.Cmds)+len(t.Inputs)+len(t.Outputs)+2)
+	values = append(values, t.Cmds...)
+	values = append(values, t.Inputs...)
+	values = append(values, t.Outputs...)
+	values = append(values, t.Dir, t.Depfile)
+
+	names := r.File.TaskFile(t).ReferencedVars(values...)
+	vars := make(map[string]string, len(names))
+	for _, name := range names {
+		vars[name] = r.File.ExpandTask(t, "${"+name+"}")
+	}
+	return vars
+}
+
+func (fp *fingerprint) record() *taskRecord {
+	return &taskRecord{
+		Fingerprint: fp.sum,
+		Inputs:      fp.inputs,
+		Cmds:        fp.cmds,
+		Dir:         fp.dir,
+		Outputs:     fp.outputs,
+		Depfile:     fp.depfile,
+		Vars:        fp.vars,
+		Env:         fp.env,
+		Time:        time.Now().UTC(),
+	}
+}
+
+// changeReason explains why fp differs from the last successful run, or
+// returns "" when the implicit inputs (vars, commands, dir, outputs) match.
+func (r *Runner) changeReason(t *remfile.Task, rec *taskRecord, fp *fingerprint) string {
+	changed := make(map[string]bool)
+	for name, val := range fp.vars {
+		if old, ok := rec.Vars[name]; !ok || old != val {
+			changed[name] = true
+		}
+	}
+	for name := range rec.Vars {
+		if _, ok := fp.vars[name]; !ok {
+			changed[name] = true
+		}
+	}
+	if len(changed) > 0 {
+		// Report the vars that changed on their own, not the ones that
+		// only changed because they expand another changed var.
+		roots := make([]string, 0, len(changed))
+		for name := range changed {
+			root := true
+			scope := r.File.TaskFile(t)
+			for _, ref := range scope.ReferencedVars(scope.RawVars[name]) {
+				if ref != name && changed[ref] {
+					root = false
+					break
+				}
+			}
+			if root {
+				roots = append(roots, name)
+			}
+		}
+		sort.Strings(roots)
+		if len(roots) == 1 {
+			return "var " + roots[0] + " changed"
+		}
+		return "vars " + strings.Join(roots, ", ") + " changed"
+	}
+
+	if names := changedKeys(rec.Env, fp.env); len(names) == 1 {
+		return "env " + names[0] + " changed"
+	} else if len(names) > 1 {
+		return "env " + strings.Join(names, ", ") + " changed"
+	}
+
+	if !slices.Equal(rec.Cmds, fp.cmds) {
+		return "commands changed"
+	}
+	if rec.Dir != fp.dir {
+		return "dir changed"
+	}
+	if !slices.Equal(rec.Outputs, fp.outputs) {
+		return "outputs changed"
+	}
+	if rec.Depfile != fp.depfile {
+		return "depfile changed"
+	}
+	if fp.inputs == nil {
+		return ""
+	}
+
+	for _, k := range sortedKeys(fp.inputs) {
+		old, ok := rec.Inputs[k]
+		if !ok {
+			return "input " + k + " added"
+		}
+		if old != fp.inputs[k] {
+			return "input " + k + " changed"
+		}
+	}
+	for _, k := range sortedKeys(rec.Inputs) {
+		if _, ok := fp.inputs[k]; !ok {
+			return "input " + k + " removed"
+		}
+	}
+	if rec.Fingerprint != fp.sum {
+		return "fingerprint changed"
+	}
+	return ""
+}
+
+// hashValue stands in for a value recorded in .rem/state, which may be a
+// secret from a dotenv file or the environment. Comparing hashes is enough
+// to tell that it changed.
+func hashValue(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func relPath(baseDir, path string) string {
+	rel, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func changedKeys(old, cur map[string]string) []string {
+	var names []string
+	for k, v := range cur {
+		if o, ok := old[k]; !ok || o != v {
+			names = append(names, k)
+		}
+	}
+	for k := range old {
+		if _, ok := cur[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
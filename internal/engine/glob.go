@@ -0,0 +1,327 @@
+package engine
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const ignoreFileName = ".remignore"
+
+type matchedFile struct {
+	Pattern string
+	Path    string
+}
+
+// pathRule is a single gitignore-style pattern. Patterns without a slash
+// match a name at any depth; a trailing slash restricts the rule to
+// directories; `**` matches any number of path segments.
+type pathRule struct {
+	segs    []string
+	negate  bool
+	dirOnly bool
+}
+
+func parsePathRule(pattern string) (pathRule, bool) {
+	pattern = strings.TrimSpace(pattern)
+	rule := pathRule{}
+	if strings.HasPrefix(pattern, "!") {
+		rule.negate = true
+		pattern = pattern[1:]
+	}
+	pattern = filepath.ToSlash(pattern)
+	if strings.HasSuffix(pattern, "/") {
+		rule.dirOnly = true
+		pattern = strings.TrimRight(pattern, "/")
+	}
+	if pattern == "" {
+		return rule, false
+	}
+
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(path.Clean(pattern), "/")
+	rule.segs = strings.Split(pattern, "/")
+	if !anchored {
+		rule.segs = append([]string{"**"}, rule.segs...)
+	}
+	return rule, true
+}
+
+// match reports whether rel (slash-separated, relative to the Remfile
+// directory) or one of its parent directories matches the rule.
+func (p pathRule) match(rel string) bool {
+	parts := strings.Split(rel, "/")
+	for i := 1; i <= len(parts); i++ {
+		if i == len(parts) && p.dirOnly {
+			break
+		}
+		if matchSegments(p.segs, parts[:i]) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			if len(pat) == 1 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		ok, err := path.Match(pat[0], name[0])
+		if err != nil || !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+type ignoreRules []pathRule
+
+var defaultIgnores = []string{".git/", stateDirName + "/"}
+
+func loadIgnoreRules(baseDir string) (ignoreRules, error) {
+	rules := make(ignoreRules, 0, len(defaultIgnores))
+	for _, p := range defaultIgnores {
+		if rule, ok := parsePathRule(p); ok {
+			rules = append(rules, rule)
+		}
+	}
+
+	f, err := os.Open(filepath.Join(baseDir, ignoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return rules, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rule, ok := parsePathRule(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, sc.Err()
+}
+
+func (rules ignoreRules) ignored(rel string) bool {
+	ignored := false
+	for _, rule := range rules {
+		if rule.match(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+func (r *Runner) ignoreRules() (ignoreRules, error) {
+	r.ignoreOnce.Do(func() {
+		r.ignore, r.ignoreErr = loadIgnoreRules(r.File.Dir)
+	})
+	return r.ignore, r.ignoreErr
+}
+
+// resolvePatterns expands inputs-style patterns into files. Positive
+// entries may be literal paths, directories or globs (including `**`);
+// entries starting with `!` remove matching files. Files found through
+// globs or directories are filtered by .remignore; literal file paths are
+// kept even when missing so their absence is tracked. Relative patterns,
+// and the `!` entries, are relative to base, the directory of the Remfile
+// that declared them. The second result lists positive globs that matched
+// nothing.
+func (r *Runner) resolvePatterns(base string, patterns []string) ([]matchedFile, []string, error) {
+	ignore, err := r.ignoreRules()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var excludes []pathRule
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if rule, ok := parsePathRule(p); ok {
+				excludes = append(excludes, rule)
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	files := make([]matchedFile, 0, 16)
+	var empty []string
+	add := func(pattern, full string) {
+		if seen[full] {
+			return
+		}
+		rel := relPath(base, full)
+		for _, ex := range excludes {
+			if ex.match(rel) {
+				return
+			}
+		}
+		seen[full] = true
+		files = append(files, matchedFile{Pattern: pattern, Path: full})
+	}
+
+	for _, pattern := range patterns {
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		full := pattern
+		if !filepath.IsAbs(full) {
+			full = filepath.Join(base, pattern)
+		}
+
+		if !hasGlob(full) {
+			info, err := os.Stat(full)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, nil, err
+			}
+			if err != nil || !info.IsDir() {
+				add(pattern, full)
+				continue
+			}
+			if err := r.walkFiles(full, ignore, func(p string) { add(pattern, p) }); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		before := len(files)
+		matched, err := r.globFiles(full, ignore)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, p := range matched {
+			add(pattern, p)
+		}
+		if len(matched) == 0 && len(files) == before {
+			empty = append(empty, pattern)
+		}
+	}
+	return files, empty, nil
+}
+
+func (r *Runner) walkFiles(root string, ignore ignoreRules, fn func(string)) error {
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p != root && ignore.ignored(relPath(r.File.Dir, p)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isFile(p, d) {
+			fn(p)
+		}
+		return nil
+	})
+}
+
+// isFile reports whether the walked entry p is a regular file or a symlink
+// to one. Symlinks to directories are not followed.
+func isFile(p string, d fs.DirEntry) bool {
+	if d.Type().IsRegular() {
+		return true
+	}
+	if d.Type()&fs.ModeSymlink == 0 {
+		return false
+	}
+	info, err := os.Stat(p)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// globRoot splits a glob into the directory before its first wildcard and
+// the remaining pattern segments.
+func globRoot(full string) (string, []string) {
+	segs := strings.Split(filepath.ToSlash(full), "/")
+	static := 0
+	for static < len(segs) && !hasGlob(segs[static]) {
+		static++
+	}
+	root := filepath.FromSlash(strings.Join(segs[:static], "/"))
+	if root == "" {
+		root = string(filepath.Separator)
+	}
+	return root, segs[static:]
+}
+
+func (r *Runner) globFiles(full string, ignore ignoreRules) ([]string, error) {
+	root, pat := globRoot(full)
+	deep := false
+	for _, s := range pat {
+		if s == "**" {
+			deep = true
+		}
+	}
+
+	if _, err := os.Stat(root); err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	out := make([]string, 0, 16)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if ignore.ignored(relPath(r.File.Dir, p)) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !matchSegments(pat, parts) {
+			if d.IsDir() && !deep && len(parts) >= len(pat) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := r.walkFiles(p, ignore, func(f string) { out = append(out, f) }); err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+		if isFile(p, d) {
+			out = append(out, p)
+		}
+		return nil
+	})
+	return out, err
+}
+
+func hasGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
@@ -0,0 +1,126 @@
+package engine
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"rem/internal/remfile"
+)
+
+func TestMatchSegmentsDoublestar(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"internal/**/*.go", "internal/engine/runner.go", true},
+		{"internal/**/*.go", "internal/runner.go", true},
+		{"internal/**/*.go", "internal/a/b/c.go", true},
+		{"internal/*/*.go", "internal/a/b/c.go", false},
+		{"**", "a/b", true},
+		{"src/**/gen", "src/gen", true},
+		{"*.go", "a/b.go", false},
+	}
+	for _, c := range cases {
+		got := matchSegments(strings.Split(c.pattern, "/"), strings.Split(c.name, "/"))
+		if got != c.want {
+			t.Fatalf("match(%q, %q) = %v, want %v", c.pattern, c.name, got, c.want)
+		}
+	}
+}
+
+func TestResolvePatternsHonorsNegationAndRemignore(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{
+		"internal/a/x.go",
+		"internal/a/b/y.go",
+		"internal/a/b/y_test.go",
+		"internal/vendor/v.go",
+		"internal/a/notes.md",
+	} {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, ignoreFileName), []byte("# vendored code\nvendor/\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{File: &remfile.File{Dir: dir}}
+	files, empty, err := r.resolvePatterns(r.File.Dir, []string{"internal/**/*.go", "!*_test.go", "gen/**/*.go"})
+	if err != nil {
+		t.Fatalf("resolvePatterns() error: %v", err)
+	}
+
+	got := make([]string, 0, len(files))
+	for _, f := range files {
+		got = append(got, relPath(dir, f.Path))
+	}
+	if strings.Join(got, ",") != "internal/a/b/y.go,internal/a/x.go" {
+		t.Fatalf("files = %v", got)
+	}
+	if len(empty) != 1 || empty[0] != "gen/**/*.go" {
+		t.Fatalf("empty globs = %v", empty)
+	}
+}
+
+func TestSymlinkedInputsAreFingerprinted(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared", "config.json")
+	if err := os.MkdirAll(filepath.Dir(shared), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(shared, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("..", "shared", "config.json"), filepath.Join(dir, "src", "config.json")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "build",
+		Order:   []string{"build"},
+		Tasks: map[string]*remfile.Task{
+			"build": {Name: "build", Inputs: []string{"src/*.json", "src"}, Outputs: []string{"out.txt"}, Cmds: []string{"cat src/config.json > out.txt"}},
+		},
+	}
+	r := &Runner{File: rf}
+	files, _, err := r.resolvePatterns(dir, rf.Tasks["build"].Inputs)
+	if err != nil {
+		t.Fatalf("resolvePatterns() error: %v", err)
+	}
+	if len(files) != 1 || relPath(dir, files[0].Path) != "src/config.json" {
+		t.Fatalf("files = %v, want the symlinked src/config.json", files)
+	}
+
+	run := func() string {
+		t.Helper()
+		var out strings.Builder
+		r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard}
+		if err := r.Run("build"); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return out.String()
+	}
+	run()
+	if got := run(); !strings.Contains(got, "[skip]") {
+		t.Fatalf("expected an up-to-date run, got:\n%s", got)
+	}
+	if err := os.WriteFile(shared, []byte("two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); strings.Contains(got, "[skip]") {
+		t.Fatalf("changing the symlink target should rerun the task, got:\n%s", got)
+	}
+}
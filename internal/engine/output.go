@@ -0,0 +1,170 @@
+package engine
+
+import (
+	"bytes"
+	"hash/fnv"
+	"io"
+	"sync"
+
+	"rem/internal/remfile"
+)
+
+type taskOutput struct {
+	stdout io.Writer
+	stderr io.Writer
+	flush  func()
+}
+
+func (r *Runner) outputMode() string {
+	if r.Output != "" {
+		return r.Output
+	}
+	if r.File.Output != "" {
+		return r.File.Output
+	}
+	return remfile.OutputInterleaved
+}
+
+func (r *Runner) openOutput(taskName string) *taskOutput {
+	return r.openOutputMode(taskName, r.outputMode())
+}
+
+// openStreamOutput opens output for a task that may never finish, where
+// grouping would hold everything back until it exits.
+func (r *Runner) openStreamOutput(taskName string) *taskOutput {
+	mode := r.outputMode()
+	if mode == remfile.OutputGrouped {
+		mode = remfile.OutputPrefixed
+	}
+	return r.openOutputMode(taskName, mode)
+}
+
+func (r *Runner) openOutputMode(taskName string, mode string) *taskOutput {
+	switch mode {
+	case remfile.OutputPrefixed:
+		prefix := r.taskPrefix(taskName)
+		stdout := &prefixWriter{mu: &r.outMu, w: r.Stdout, prefix: prefix}
+		stderr := &prefixWriter{mu: &r.outMu, w: r.Stderr, prefix: prefix}
+		return &taskOutput{
+			stdout: stdout,
+			stderr: stderr,
+			flush: func() {
+				stdout.Flush()
+				stderr.Flush()
+			},
+		}
+	case remfile.OutputGrouped:
+		g := &outputGroup{}
+		return &taskOutput{
+			stdout: g.writer(r.Stdout),
+			stderr: g.writer(r.Stderr),
+			flush:  func() { g.flush(&r.outMu) },
+		}
+	}
+	return &taskOutput{stdout: r.Stdout, stderr: r.Stderr, flush: func() {}}
+}
+
+var prefixColors = []string{"32", "33", "34", "35", "36", "92", "93", "94", "95", "96"}
+
+func (r *Runner) taskPrefix(taskName string) string {
+	width := 0
+	for _, name := range r.File.Order {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	label := taskName
+	for len(label) < width {
+		label += " "
+	}
+
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(taskName))
+	color := prefixColors[h.Sum32()%uint32(len(prefixColors))]
+	return r.paint(color, label+" |") + " "
+}
+
+// prefixWriter tags every complete line with the task prefix. Partial lines
+// are held back until their newline arrives or the task finishes.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	end := bytes.LastIndexByte(p.buf, '\n')
+	if end < 0 {
+		return len(b), nil
+	}
+
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(p.buf[:end+1], []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		out.WriteString(p.prefix)
+		out.Write(line)
+	}
+	p.buf = append(p.buf[:0], p.buf[end+1:]...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) Flush() {
+	if len(p.buf) == 0 {
+		return
+	}
+	_, _ = p.Write([]byte("\n"))
+}
+
+type outputChunk struct {
+	w    io.Writer
+	data []byte
+}
+
+// outputGroup buffers a task's stdout and stderr in write order so the whole
+// task can be flushed at once without interleaving with other tasks.
+type outputGroup struct {
+	mu     sync.Mutex
+	chunks []outputChunk
+}
+
+func (g *outputGroup) writer(w io.Writer) io.Writer {
+	return groupWriter{g: g, w: w}
+}
+
+func (g *outputGroup) flush(outMu *sync.Mutex) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	outMu.Lock()
+	defer outMu.Unlock()
+	for _, c := range g.chunks {
+		_, _ = c.w.Write(c.data)
+	}
+	g.chunks = nil
+}
+
+type groupWriter struct {
+	g *outputGroup
+	w io.Writer
+}
+
+func (gw groupWriter) Write(b []byte) (int, error) {
+	gw.g.mu.Lock()
+	defer gw.g.mu.Unlock()
+	n := len(gw.g.chunks)
+	if n > 0 && gw.g.chunks[n-1].w == gw.w {
+		gw.g.chunks[n-1].data = append(gw.g.chunks[n-1].data, b...)
+	} else {
+		gw.g.chunks = append(gw.g.chunks, outputChunk{w: gw.w, data: append([]byte(nil), b...)})
+	}
+	return len(b), nil
+}
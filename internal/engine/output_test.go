@@ -0,0 +1,51 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"rem/internal/remfile"
+)
+
+func TestPrefixWriterTagsCompleteLines(t *testing.T) {
+	var out bytes.Buffer
+	w := &prefixWriter{mu: &sync.Mutex{}, w: &out, prefix: "gen | "}
+
+	_, _ = w.Write([]byte("one\ntw"))
+	_, _ = w.Write([]byte("o\nthree"))
+	w.Flush()
+
+	want := "gen | one\ngen | two\ngen | three\n"
+	if out.String() != want {
+		t.Fatalf("output = %q, want %q", out.String(), want)
+	}
+}
+
+func TestGroupedOutputKeepsTasksContiguous(t *testing.T) {
+	rf := &remfile.File{
+		Dir:     t.TempDir(),
+		Default: "all",
+		Order:   []string{"a", "b", "all"},
+		Tasks: map[string]*remfile.Task{
+			"a":   {Name: "a", Cmds: []string{"echo a1; sleep 0.1; echo a2; sleep 0.1; echo a3"}},
+			"b":   {Name: "b", Cmds: []string{"sleep 0.05; echo b1; sleep 0.1; echo b2; sleep 0.1; echo b3"}},
+			"all": {Name: "all", Deps: []string{"a", "b"}},
+		},
+	}
+
+	var out bytes.Buffer
+	r := &Runner{File: rf, Jobs: 2, Stdout: &out, Stderr: io.Discard, Output: remfile.OutputGrouped}
+	if err := r.Run("all"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	text := out.String()
+	for _, block := range []string{"a1\na2\na3\n", "b1\nb2\nb3\n"} {
+		if !strings.Contains(text, block) {
+			t.Fatalf("task output was interleaved, missing %q in:\n%s", block, text)
+		}
+	}
+}
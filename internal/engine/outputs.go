@@ -0,0 +1,121 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"rem/internal/remfile"
+)
+
+type outputSet struct {
+	files   []string
+	missing []string
+	oldest  time.Time
+	newest  map[string]time.Time
+}
+
+func declaredOutputs(patterns []string) (positive []string, negative []string) {
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			negative = append(negative, p)
+		} else {
+			positive = append(positive, p)
+		}
+	}
+	return positive, negative
+}
+
+// resolveOutputs expands a task's outputs into the files they currently
+// cover. Directories stand for every file below them and globs for every
+// match; a declared output that covers no file is reported as missing.
+func (r *Runner) resolveOutputs(t *remfile.Task) (outputSet, error) {
+	positive, negative := declaredOutputs(r.File.ExpandTaskList(t, t.Outputs))
+	set := outputSet{newest: make(map[string]time.Time, len(positive))}
+	seen := make(map[string]bool)
+
+	for _, out := range positive {
+		files, _, err := r.resolvePatterns(r.File.TaskFile(t).Dir, append([]string{out}, negative...))
+		if err != nil {
+			return outputSet{}, err
+		}
+
+		found := 0
+		for _, f := range files {
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return outputSet{}, err
+			}
+			found++
+			if info.ModTime().After(set.newest[out]) {
+				set.newest[out] = info.ModTime()
+			}
+			if seen[f.Path] {
+				continue
+			}
+			seen[f.Path] = true
+			set.files = append(set.files, f.Path)
+			if set.oldest.IsZero() || info.ModTime().Before(set.oldest) {
+				set.oldest = info.ModTime()
+			}
+		}
+		if found == 0 {
+			set.missing = append(set.missing, out)
+		}
+	}
+	return set, nil
+}
+
+// verifyOutputs checks that every declared output exists and that at least
+// one file it covers was written after start. Depending on the task's
+// verify mode a problem fails the task or is only reported as a warning.
+func (r *Runner) verifyOutputs(t *remfile.Task, start time.Time, out *taskOutput) error {
+	mode := t.Verify
+	if mode == "" {
+		mode = remfile.VerifyStrict
+	}
+	if mode == remfile.VerifyStrict && r.LenientOutputs {
+		mode = remfile.VerifyWarn
+	}
+	positive, _ := declaredOutputs(r.File.ExpandTaskList(t, t.Outputs))
+	if mode == remfile.VerifyOff || len(positive) == 0 {
+		return nil
+	}
+
+	set, err := r.resolveOutputs(t)
+	if err != nil {
+		return err
+	}
+
+	// Filesystems may store mtimes with one-second precision.
+	since := start.Truncate(time.Second)
+	var stale []string
+	for _, p := range positive {
+		newest, ok := set.newest[p]
+		if ok && newest.Before(since) {
+			stale = append(stale, p)
+		}
+	}
+
+	problems := make([]string, 0, 2)
+	if len(set.missing) > 0 {
+		problems = append(problems, "declared outputs were not produced: "+strings.Join(set.missing, ", "))
+	}
+	if len(stale) > 0 {
+		problems = append(problems, "declared outputs were not updated by this run: "+strings.Join(stale, ", "))
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+
+	msg := strings.Join(problems, "; ")
+	if mode == remfile.VerifyWarn {
+		fmt.Fprintf(out.stderr, "%s %s: %s\n", r.paint("33", "[warn]"), t.Name, msg)
+		return nil
+	}
+	return fmt.Errorf("%s", msg)
+}
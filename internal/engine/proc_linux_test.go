@@ -0,0 +1,209 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"rem/internal/remfile"
+)
+
+func TestInterruptKillsProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "serve",
+		Order:   []string{"serve"},
+		Tasks: map[string]*remfile.Task{
+			"serve": {Name: "serve", Cmds: []string{"sleep 30 & echo $! > child.pid; wait"}},
+		},
+	}
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}()
+
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard, GracePeriod: 200 * time.Millisecond}
+	err := r.Run("serve")
+	if code := ExitCode(err); code != ExitInterrupted {
+		t.Fatalf("ExitCode() = %d, want %d (err=%v)", code, ExitInterrupted, err)
+	}
+	runErr := err.(*RunError)
+	if len(runErr.Interrupted) != 1 || runErr.Interrupted[0] != "serve" {
+		t.Fatalf("interrupted = %#v", runErr.Interrupted)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(dir, "child.pid"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(raw)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for !processGone(pid) {
+		if time.Now().After(deadline) {
+			t.Fatalf("grandchild %d still running after interrupt", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestTaskReadsControllingTerminal(t *testing.T) {
+	if os.Getenv(terminalTaskEnv) != "" {
+		runTerminalTask()
+		return
+	}
+
+	out := runOnTerminal(t, "TestTaskReadsControllingTerminal", "ask", "hello\n")
+	if !strings.Contains(out, "got hello") {
+		t.Fatalf("task reading the terminal, output:\n%s", out)
+	}
+}
+
+func TestTimeoutOnTerminalKillsProcessGroup(t *testing.T) {
+	if os.Getenv(terminalTaskEnv) != "" {
+		runTerminalTask()
+		return
+	}
+
+	dir := t.TempDir()
+	t.Setenv("REM_TEST_TERMINAL_DIR", dir)
+	out := runOnTerminal(t, "TestTimeoutOnTerminalKillsProcessGroup", "timeout", "")
+	if !strings.Contains(out, "timed out after 300ms") {
+		t.Fatalf("expected the task to time out, output:\n%s", out)
+	}
+}
+
+const terminalTaskEnv = "REM_TEST_TERMINAL_TASK"
+
+// runOnTerminal reruns the test binary as a session leader whose
+// controlling terminal is a new pseudo-terminal, runs the named task of
+// runTerminalTask there, types input and returns what the terminal showed.
+func runOnTerminal(t *testing.T, test, task, input string) string {
+	t.Helper()
+	ptmx, tty := openPTY(t)
+	defer ptmx.Close()
+	cmd := exec.Command(os.Args[0], "-test.run=^"+test+"$")
+	cmd.Env = append(os.Environ(), terminalTaskEnv+"="+task)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	tty.Close()
+
+	var out bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(&out, ptmx)
+		close(copied)
+	}()
+	if input != "" {
+		if _, err := ptmx.Write([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		<-copied
+		if err != nil {
+			t.Fatalf("task %q on a terminal: %v, output:\n%s", task, err, out.String())
+		}
+		return out.String()
+	case <-time.After(10 * time.Second):
+		_ = cmd.Process.Kill()
+		t.Fatalf("task %q on a terminal hung", task)
+		return ""
+	}
+}
+
+func runTerminalTask() {
+	dir := os.Getenv("REM_TEST_TERMINAL_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	rf := &remfile.File{
+		Dir:   dir,
+		Order: []string{"ask", "timeout"},
+		Tasks: map[string]*remfile.Task{
+			"ask":     {Name: "ask", Cmds: []string{`read x; echo "got $x"`}},
+			"timeout": {Name: "timeout", Timeout: 300 * time.Millisecond, Cmds: []string{"sleep 30 & echo $! > child.pid; wait"}},
+		},
+	}
+	task := os.Getenv(terminalTaskEnv)
+	r := &Runner{File: rf, Jobs: 1, Stdout: os.Stdout, Stderr: os.Stdout, GracePeriod: 200 * time.Millisecond}
+	start := time.Now()
+	err := r.Run(task)
+	if task == "timeout" {
+		// The grandchild has to be gone before this process exits: the
+		// terminal hangs up its foreground group when the session ends.
+		if err == nil || time.Since(start) > 2*time.Second {
+			fmt.Printf("timeout run took %v: %v\n", time.Since(start), err)
+			os.Exit(1)
+		}
+		raw, _ := os.ReadFile(filepath.Join(dir, "child.pid"))
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(raw)))
+		deadline := time.Now().Add(time.Second)
+		for pid == 0 || !processGone(pid) {
+			if time.Now().After(deadline) {
+				fmt.Printf("grandchild %d still running after the task timed out\n", pid)
+				os.Exit(1)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// openPTY opens a pseudo-terminal pair, skipping the test where there is
+// none.
+func openPTY(t *testing.T) (ptmx, tty *os.File) {
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminal: %v", err)
+	}
+	var unlock int32
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		ptmx.Close()
+		t.Skipf("unlock pseudo-terminal: %v", errno)
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ptmx.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		ptmx.Close()
+		t.Skipf("pseudo-terminal number: %v", errno)
+	}
+	tty, err = os.OpenFile("/dev/pts/"+strconv.Itoa(int(n)), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		ptmx.Close()
+		t.Skipf("open pseudo-terminal: %v", err)
+	}
+	return ptmx, tty
+}
+
+func processGone(pid int) bool {
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return true
+	}
+	return strings.Contains(string(stat), ") Z ")
+}
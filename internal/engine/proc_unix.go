@@ -0,0 +1,93 @@
+//go:build !windows
+
+package engine
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var interruptSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// configureProcess runs cmd in its own process group so cancellation reaches
+// every process the task shell spawned, not only the shell itself.
+//
+// A background process group is stopped by SIGTTIN as soon as it reads the
+// terminal, so with foreground set the group is handed rem's controlling
+// terminal while it runs. The terminal then sends ^C to the group rather
+// than to rem.
+//
+// The returned func must be called once cmd has been waited for. It stops
+// the SIGKILL fallback, so the kill cannot reach a reused process group,
+// takes the terminal back, and reports whether the group was interrupted
+// from the terminal.
+func configureProcess(cmd *exec.Cmd, sig func() os.Signal, grace time.Duration, foreground bool) func() (interrupted bool) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if foreground {
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+
+	var mu sync.Mutex
+	var kill *time.Timer
+	cmd.Cancel = func() error {
+		pgid := -cmd.Process.Pid
+		s, ok := sig().(syscall.Signal)
+		if !ok {
+			s = syscall.SIGTERM
+		}
+		err := syscall.Kill(pgid, s)
+		mu.Lock()
+		kill = time.AfterFunc(grace, func() {
+			_ = syscall.Kill(pgid, syscall.SIGKILL)
+		})
+		mu.Unlock()
+		return err
+	}
+	cmd.WaitDelay = grace + time.Second
+	return func() bool {
+		mu.Lock()
+		if kill != nil {
+			kill.Stop()
+		}
+		mu.Unlock()
+		if !foreground {
+			return false
+		}
+		reclaimTerminal(os.Stdin)
+		if cmd.ProcessState == nil {
+			return false
+		}
+		ws, ok := cmd.ProcessState.Sys().(syscall.WaitStatus)
+		return ok && ws.Signaled() && (ws.Signal() == syscall.SIGINT || ws.Signal() == syscall.SIGQUIT)
+	}
+}
+
+// terminalStdin reports whether stdin is rem's controlling terminal and rem
+// is its foreground process group, the only case where commands reading
+// stdin need the terminal handed to them.
+func terminalStdin() bool {
+	var pgrp int32
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), uintptr(syscall.TIOCGPGRP), uintptr(unsafe.Pointer(&pgrp)))
+	return errno == 0 && int(pgrp) == syscall.Getpgrp()
+}
+
+// reclaimTerminal makes rem's process group the foreground group of f again.
+// rem is in the background at this point, so SIGTTOU is ignored meanwhile.
+func reclaimTerminal(f *os.File) {
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	pgrp := int32(syscall.Getpgrp())
+	_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&pgrp)))
+}
+
+// raiseInterrupt passes an interrupt the terminal sent to a command's group
+// on to rem.
+func raiseInterrupt() {
+	_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+}
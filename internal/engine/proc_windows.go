@@ -0,0 +1,24 @@
+//go:build windows
+
+package engine
+
+import (
+	"os"
+	"os/exec"
+	"time"
+)
+
+var interruptSignals = []os.Signal{os.Interrupt}
+
+func configureProcess(cmd *exec.Cmd, sig func() os.Signal, grace time.Duration, foreground bool) func() bool {
+	cmd.WaitDelay = grace
+	return func() bool { return false }
+}
+
+// terminalStdin is false on Windows, where console reads do not depend on
+// process groups.
+func terminalStdin() bool {
+	return false
+}
+
+func raiseInterrupt() {}
@@ -2,26 +2,56 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"rem/internal/cache"
 	"rem/internal/remfile"
 	"rem/internal/shellcfg"
 )
 
 type Runner struct {
-	File     *remfile.File
-	Jobs     int
-	Stdout   io.Writer
-	Stderr   io.Writer
-	Colorize bool
+	File        *remfile.File
+	Jobs        int
+	Stdout      io.Writer
+	Stderr      io.Writer
+	Colorize    bool
+	Cache       cache.Store
+	GracePeriod time.Duration
+	Output      string
+	Events      EventSink
+	DryRun      bool
+	Ordered     bool
+
+	// FailFast cancels every running task at the first failure. By default
+	// only the failed task's dependents are skipped and independent tasks
+	// keep going.
+	FailFast bool
+
+	LenientOutputs bool
+
+	WatchDebounce time.Duration
+	WatchInterval time.Duration
+	WatchPoll     bool
+
+	state *stateDB
+	outMu sync.Mutex
+
+	ignoreOnce sync.Once
+	ignore     ignoreRules
+	ignoreErr  error
+
+	services *serviceSet
 }
 
 type taskResult struct {
@@ -31,11 +61,103 @@ type taskResult struct {
 
 type taskState struct {
 	remaining int
-	failedDep bool
+	blockedBy string
 	done      bool
 }
 
-func (r *Runner) Run(target string) error {
+type TaskFailure struct {
+	Task string
+	Err  error
+}
+
+type BlockedTask struct {
+	Task      string
+	FailedDep string
+}
+
+type RunError struct {
+	Failed      []TaskFailure
+	Blocked     []BlockedTask
+	Canceled    []string
+	Interrupted []string
+	Signal      os.Signal
+}
+
+func (e *RunError) Error() string {
+	if e.Signal != nil {
+		return fmt.Sprintf("run interrupted by %s: %d task(s) interrupted", e.Signal, len(e.Interrupted))
+	}
+	if len(e.Failed) == 1 && len(e.Blocked) == 0 && len(e.Canceled) == 0 {
+		return fmt.Sprintf("task %q failed: %v", e.Failed[0].Task, e.Failed[0].Err)
+	}
+
+	parts := make([]string, 0, 3)
+	if len(e.Failed) > 0 {
+		parts = append(parts, fmt.Sprintf("%d failed", len(e.Failed)))
+	}
+	if len(e.Blocked) > 0 {
+		parts = append(parts, fmt.Sprintf("%d blocked", len(e.Blocked)))
+	}
+	if len(e.Canceled) > 0 {
+		parts = append(parts, fmt.Sprintf("%d canceled", len(e.Canceled)))
+	}
+	return "run failed: " + strings.Join(parts, ", ") + " task(s)"
+}
+
+func (e *RunError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed)+1)
+	if e.Signal != nil {
+		errs = append(errs, ErrInterrupted)
+	}
+	for _, f := range e.Failed {
+		errs = append(errs, f.Err)
+	}
+	return errs
+}
+
+var ErrInterrupted = errors.New("interrupted")
+
+const (
+	ExitFailure     = 1
+	ExitInterrupted = 130
+)
+
+// ExitCode maps a Run error to a process exit status. Interrupted runs exit
+// with 128+signal (130 for SIGINT) so callers can tell them from failures.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var runErr *RunError
+	if errors.As(err, &runErr) && runErr.Signal != nil {
+		if sig, ok := runErr.Signal.(syscall.Signal); ok && sig > 0 {
+			return 128 + int(sig)
+		}
+		return ExitInterrupted
+	}
+	return ExitFailure
+}
+
+const defaultGracePeriod = 5 * time.Second
+
+type interruptCause struct {
+	sig os.Signal
+}
+
+func (c interruptCause) Error() string {
+	return "interrupted by " + c.sig.String()
+}
+
+// Run executes the given targets and their dependencies as one scheduled
+// graph, so shared dependencies run once. Without targets the Remfile
+// default is used.
+func (r *Runner) Run(targets ...string) error {
+	return r.run(context.Background(), targets, nil)
+}
+
+// run executes the targets' subset. When only is non-nil, tasks outside it
+// are left out and treated as already satisfied for their dependents.
+func (r *Runner) run(parent context.Context, targets []string, only map[string]bool) error {
 	if r.File == nil {
 		return fmt.Errorf("runner has no loaded Remfile")
 	}
@@ -45,48 +167,65 @@ func (r *Runner) Run(target string) error {
 	if r.Stderr == nil {
 		r.Stderr = os.Stderr
 	}
-	if target == "" {
-		target = r.File.DefaultTarget()
-	}
-	target = r.File.ExpandString(target)
-	if _, ok := r.File.Tasks[target]; !ok {
-		return fmt.Errorf("target %q does not exist", target)
+	switch r.outputMode() {
+	case remfile.OutputInterleaved, remfile.OutputPrefixed, remfile.OutputGrouped:
+	default:
+		return fmt.Errorf("unknown output mode %q", r.outputMode())
 	}
-
-	subset, err := r.collectSubset(target)
+	targets, err := r.resolveTargets(targets)
 	if err != nil {
 		return err
 	}
-
-	jobs := r.Jobs
-	if jobs < 1 {
-		jobs = runtime.NumCPU()
+	subset, after, err := r.collectTargets(targets)
+	if err != nil {
+		return err
 	}
-
-	dependents := make(map[string][]string, len(subset))
-	state := make(map[string]taskState, len(subset))
-	for name := range subset {
-		t := r.File.Tasks[name]
-		rem := 0
-		for _, dep := range r.File.ExpandList(t.Deps) {
-			if subset[dep] {
-				rem++
-				dependents[dep] = append(dependents[dep], name)
+	if only != nil {
+		for name := range subset {
+			if !only[name] {
+				delete(subset, name)
 			}
 		}
-		state[name] = taskState{remaining: rem}
+	}
+	if r.state == nil {
+		st, err := loadState(r.File.Dir)
+		if err != nil {
+			return fmt.Errorf("load state: %w", err)
+		}
+		r.state = st
 	}
 
-	ready := make([]string, 0, len(subset))
+	dependents, state, ready := r.buildGraph(subset, after)
+	if r.DryRun {
+		return r.dryRun(r.scheduleOrder(dependents, state, ready))
+	}
+
+	jobs := r.jobs()
+
+	runStart := time.Now()
+	planned := make([]string, 0, len(subset))
 	for _, name := range r.File.Order {
-		st, ok := state[name]
-		if ok && st.remaining == 0 {
-			ready = append(ready, name)
+		if subset[name] {
+			planned = append(planned, name)
 		}
 	}
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	target := strings.Join(targets, " ")
+	r.emit(Event{Type: EventRunStarted, Target: target, Targets: targets, Tasks: planned})
+
+	ctx, cancelCause := context.WithCancelCause(parent)
+	defer cancelCause(nil)
+	cancel := func() { cancelCause(nil) }
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, interruptSignals...)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			cancelCause(interruptCause{sig: sig})
+		case <-ctx.Done():
+		}
+	}()
 
 	taskCh := make(chan string)
 	resultCh := make(chan taskResult, jobs)
@@ -96,6 +235,10 @@ func (r *Runner) Run(target string) error {
 		go func() {
 			defer wg.Done()
 			for name := range taskCh {
+				if ctx.Err() != nil {
+					resultCh <- taskResult{name: name, err: ctx.Err()}
+					continue
+				}
 				resultCh <- taskResult{name: name, err: r.executeTask(ctx, name)}
 			}
 		}()
@@ -104,10 +247,25 @@ func (r *Runner) Run(target string) error {
 	total := len(subset)
 	completed := 0
 	running := 0
-	var firstErr error
+	stopping := false
+	runErr := &RunError{}
+
+	finish := func(name string, blockedBy string) {
+		for _, dep := range dependents[name] {
+			next := state[dep]
+			next.remaining--
+			if blockedBy != "" && next.blockedBy == "" {
+				next.blockedBy = blockedBy
+			}
+			state[dep] = next
+			if next.remaining == 0 {
+				ready = append(ready, dep)
+			}
+		}
+	}
 
 	dispatch := func() {
-		for running < jobs && len(ready) > 0 {
+		for !stopping && ctx.Err() == nil && running < jobs && len(ready) > 0 {
 			name := ready[0]
 			ready = ready[1:]
 
@@ -116,26 +274,18 @@ func (r *Runner) Run(target string) error {
 				continue
 			}
 
-			if st.failedDep {
+			if st.blockedBy != "" {
 				st.done = true
 				state[name] = st
 				completed++
-				if firstErr == nil {
-					firstErr = fmt.Errorf("task %q blocked by failed dependency", name)
-				}
-				for _, dep := range dependents[name] {
-					next := state[dep]
-					next.remaining--
-					next.failedDep = true
-					state[dep] = next
-					if next.remaining == 0 {
-						ready = append(ready, dep)
-					}
-				}
+				runErr.Blocked = append(runErr.Blocked, BlockedTask{Task: name, FailedDep: st.blockedBy})
+				r.emit(Event{Type: EventTaskFinished, Task: name, Status: StatusBlocked, Reason: st.blockedBy + " failed"})
+				finish(name, st.blockedBy)
 				continue
 			}
 
 			running++
+			r.emit(Event{Type: EventTaskQueued, Task: name})
 			taskCh <- name
 		}
 	}
@@ -145,7 +295,7 @@ func (r *Runner) Run(target string) error {
 		if completed >= total {
 			break
 		}
-		if running == 0 && len(ready) == 0 {
+		if running == 0 && (stopping || ctx.Err() != nil || len(ready) == 0) {
 			break
 		}
 
@@ -160,119 +310,382 @@ func (r *Runner) Run(target string) error {
 		state[res.name] = st
 		completed++
 
-		if res.err != nil && firstErr == nil {
-			firstErr = fmt.Errorf("task %q failed: %w", res.name, res.err)
+		if res.err == nil {
+			finish(res.name, "")
+			continue
+		}
+		if ctx.Err() != nil {
+			stopping = true
+			if interruptSignal(ctx) != nil {
+				runErr.Interrupted = append(runErr.Interrupted, res.name)
+			} else {
+				runErr.Canceled = append(runErr.Canceled, res.name)
+			}
+			continue
+		}
+
+		runErr.Failed = append(runErr.Failed, TaskFailure{Task: res.name, Err: res.err})
+		finish(res.name, res.name)
+		if r.FailFast {
+			stopping = true
+			cancel()
+		}
+	}
+
+	close(taskCh)
+	wg.Wait()
+
+	for _, name := range r.File.Order {
+		if subset[name] && !state[name].done {
+			runErr.Canceled = append(runErr.Canceled, name)
+			r.emit(Event{Type: EventTaskFinished, Task: name, Status: StatusCanceled})
 		}
+	}
+
+	runErr.Signal = interruptSignal(ctx)
+	if runErr.Signal == nil && len(runErr.Failed) == 0 && len(runErr.Blocked) == 0 && len(runErr.Canceled) == 0 {
+		r.emit(Event{Type: EventRunFinished, Target: target, Status: StatusOK, DurationMS: durationMS(runStart)})
+		return nil
+	}
+	r.printSummary(runErr)
+	r.emit(Event{Type: EventRunFinished, Target: target, Status: StatusFailed, Error: runErr.Error(), DurationMS: durationMS(runStart)})
+	return runErr
+}
+
+func (r *Runner) printSummary(e *RunError) {
+	for _, f := range e.Failed {
+		fmt.Fprintf(r.Stderr, "%s %s: %v\n", r.paint("31", "[fail]"), f.Task, f.Err)
+	}
+	for _, b := range e.Blocked {
+		fmt.Fprintf(r.Stderr, "%s %s (%s failed)\n", r.paint("31", "[blocked]"), b.Task, b.FailedDep)
+	}
+	for _, name := range e.Interrupted {
+		fmt.Fprintf(r.Stderr, "%s %s\n", r.paint("31", "[interrupted]"), name)
+	}
+	for _, name := range e.Canceled {
+		fmt.Fprintf(r.Stderr, "%s %s\n", r.paint("33", "[canceled]"), name)
+	}
+}
+
+func interruptSignal(ctx context.Context) os.Signal {
+	var cause interruptCause
+	if errors.As(context.Cause(ctx), &cause) {
+		return cause.sig
+	}
+	return nil
+}
 
-		for _, dep := range dependents[res.name] {
+func (r *Runner) gracePeriod() time.Duration {
+	if r.GracePeriod > 0 {
+		return r.GracePeriod
+	}
+	return defaultGracePeriod
+}
+
+// buildGraph indexes the dependents of every task in subset. after adds
+// ordering edges on top of the declared deps.
+func (r *Runner) buildGraph(subset map[string]bool, after map[string][]string) (map[string][]string, map[string]taskState, []string) {
+	dependents := make(map[string][]string, len(subset))
+	state := make(map[string]taskState, len(subset))
+	for name := range subset {
+		t := r.File.Tasks[name]
+		rem := 0
+		for _, dep := range append(r.File.ExpandTaskList(t, t.Deps), after[name]...) {
+			if subset[dep] {
+				rem++
+				dependents[dep] = append(dependents[dep], name)
+			}
+		}
+		state[name] = taskState{remaining: rem}
+	}
+
+	ready := make([]string, 0, len(subset))
+	for _, name := range r.File.Order {
+		st, ok := state[name]
+		if ok && st.remaining == 0 {
+			ready = append(ready, name)
+		}
+	}
+	return dependents, state, ready
+}
+
+// scheduleOrder replays the dispatch loop of Run with a single job and
+// returns the order tasks would start in.
+func (r *Runner) scheduleOrder(dependents map[string][]string, state map[string]taskState, ready []string) []string {
+	order := make([]string, 0, len(state))
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dep := range dependents[name] {
 			next := state[dep]
 			next.remaining--
-			if res.err != nil {
-				next.failedDep = true
-			}
 			state[dep] = next
 			if next.remaining == 0 {
 				ready = append(ready, dep)
 			}
 		}
 	}
+	return order
+}
 
-	close(taskCh)
-	wg.Wait()
+func (r *Runner) dryRun(order []string) error {
+	for _, name := range order {
+		task := r.File.Tasks[name]
+		upToDate, reason, _, err := r.isUpToDate(task)
+		if err != nil {
+			return fmt.Errorf("task %q: %w", name, err)
+		}
+		if upToDate {
+			fmt.Fprintf(r.Stdout, "%s %s (%s)\n", r.paint("33", "[would skip]"), name, reason)
+			continue
+		}
 
-	return firstErr
+		fmt.Fprintf(r.Stdout, "%s %s (%s)\n", r.paint("34", "[would run]"), name, reason)
+		cmds := r.taskCommands(task)
+		if len(cmds) == 0 {
+			continue
+		}
+		fmt.Fprintf(r.Stdout, "  %s %s\n", r.paint("2", "dir:"), r.taskDir(task))
+		for _, cmdText := range cmds {
+			fmt.Fprintf(r.Stdout, "  %s %s\n", r.paint("2", "$"), cmdText)
+		}
+	}
+	return nil
 }
 
-func (r *Runner) executeTask(ctx context.Context, taskName string) error {
+func (r *Runner) executeTask(ctx context.Context, taskName string) (err error) {
 	task := r.File.Tasks[taskName]
+	out := r.openOutput(taskName)
+	defer out.flush()
 
-	upToDate, reason, err := r.isUpToDate(task)
+	start := time.Now()
+	status := StatusOK
+	defer func() {
+		ev := Event{Type: EventTaskFinished, Task: taskName, Status: status, DurationMS: durationMS(start)}
+		if err != nil {
+			ev.Status = StatusFailed
+			if ctx.Err() != nil {
+				ev.Status = StatusCanceled
+			}
+			ev.Error = err.Error()
+		}
+		r.emit(ev)
+	}()
+
+	upToDate, reason, fp, err := r.isUpToDate(task)
 	if err != nil {
 		return err
 	}
 	if upToDate {
-		fmt.Fprintf(r.Stdout, "%s %s (%s)\n", r.paint("33", "[skip]"), taskName, reason)
+		status = StatusSkipped
+		r.emit(Event{Type: EventTaskSkipped, Task: taskName, Reason: reason})
+		fmt.Fprintf(out.stdout, "%s %s (%s)\n", r.paint("33", "[skip]"), taskName, reason)
 		return nil
 	}
 
-	fmt.Fprintf(r.Stdout, "%s %s\n", r.paint("34", "[run]"), taskName)
-	for _, rawCmd := range task.Cmds {
-		rawCmd = r.File.ExpandString(rawCmd)
-		cmdText := strings.TrimSpace(rawCmd)
-		if cmdText == "" {
-			continue
+	if r.restoreOutputs(task, fp) {
+		status = StatusCached
+		fmt.Fprintf(out.stdout, "%s %s (%s, restored from cache)\n", r.paint("36", "[cache]"), taskName, reason)
+		return r.state.put(taskName, fp.record())
+	}
+
+	if task.Restart && r.services != nil {
+		fmt.Fprintf(out.stdout, "%s %s (%s)\n", r.paint("35", "[start]"), taskName, reason)
+		r.startService(task)
+		return nil
+	}
+
+	fmt.Fprintf(out.stdout, "%s %s (%s)\n", r.paint("34", "[run]"), taskName, reason)
+	runStart := time.Now()
+	attempts := task.Retries + 1
+	backoff := task.Backoff
+	for attempt := 1; ; attempt++ {
+		err := r.runCommands(ctx, task, out, attempt)
+		if err == nil {
+			break
+		}
+		if attempt >= attempts || ctx.Err() != nil {
+			if attempts > 1 {
+				return fmt.Errorf("attempt %d/%d: %w", attempt, attempts, err)
+			}
+			return err
 		}
 
-		fmt.Fprintf(r.Stdout, "  %s %s\n", r.paint("2", "$"), cmdText)
-		cmd := shellCommand(ctx, cmdText)
-		cmd.Stdout = r.Stdout
-		cmd.Stderr = r.Stderr
-		cmd.Stdin = os.Stdin
-		cmd.Env = os.Environ()
-		taskDir := r.File.ExpandString(task.Dir)
-		if taskDir != "" {
-			if filepath.IsAbs(taskDir) {
-				cmd.Dir = taskDir
-			} else {
-				cmd.Dir = filepath.Join(r.File.Dir, taskDir)
+		wait := ""
+		if backoff > 0 {
+			wait = fmt.Sprintf(", waiting %s", backoff)
+		}
+		fmt.Fprintf(out.stdout, "%s %s (attempt %d/%d failed: %v%s)\n", r.paint("33", "[retry]"), taskName, attempt, attempts, err, wait)
+		if backoff > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
 			}
-		} else {
-			cmd.Dir = r.File.Dir
+			backoff *= 2
 		}
-		if err := cmd.Run(); err != nil {
+	}
+
+	if err := r.verifyOutputs(task, runStart, out); err != nil {
+		return err
+	}
+
+	if fp != nil {
+		rec := fp.record()
+		if fp.depfile != "" {
+			if rec.Discovered, err = r.discoverInputs(task, fp.depfile); err != nil {
+				return err
+			}
+		}
+		if err := r.state.put(taskName, rec); err != nil {
 			return err
 		}
+		r.storeOutputs(task, fp)
 	}
 	return nil
 }
 
-func (r *Runner) isUpToDate(t *remfile.Task) (bool, string, error) {
-	outputs := r.File.ExpandList(t.Outputs)
-	inputs := r.File.ExpandList(t.Inputs)
-
-	if len(outputs) == 0 {
-		return false, "no outputs", nil
+func (r *Runner) runCommands(ctx context.Context, task *remfile.Task, out *taskOutput, attempt int) error {
+	if task.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, task.Timeout)
+		defer cancel()
 	}
 
-	oldestOutput := time.Time{}
-	for _, out := range outputs {
-		full := out
-		if !filepath.IsAbs(full) {
-			full = filepath.Join(r.File.Dir, out)
+	for _, cmdText := range r.taskCommands(task) {
+		if err := ctx.Err(); err != nil {
+			return r.commandError(ctx, task, err)
+		}
+		fmt.Fprintf(out.stdout, "  %s %s\n", r.paint("2", "$"), cmdText)
+		foreground := r.commandsOwnTerminal()
+		cmd, release := r.shellCommand(ctx, cmdText, foreground)
+		cmd.Stdout = out.stdout
+		cmd.Stderr = out.stderr
+		if foreground || !terminalStdin() {
+			// Otherwise stdin is the terminal and parallel jobs cannot all
+			// read it, so commands get /dev/null.
+			cmd.Stdin = os.Stdin
+		}
+		cmd.Env = r.taskEnv(task)
+		cmd.Dir = r.taskDir(task)
+
+		r.emit(Event{Type: EventCommandStarted, Task: task.Name, Command: cmdText, Dir: cmd.Dir, Attempt: attempt})
+		start := time.Now()
+		err := cmd.Run()
+		if release() {
+			// ^C went to the command's group, which held the terminal;
+			// pass it on so the whole run stops rather than this task.
+			raiseInterrupt()
+			select {
+			case <-ctx.Done():
+			case <-time.After(r.gracePeriod()):
+			}
 		}
-		info, err := os.Stat(full)
+		code := 0
 		if err != nil {
-			if os.IsNotExist(err) {
-				return false, "missing output", nil
+			code = -1
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				code = exitErr.ExitCode()
 			}
-			return false, "", err
 		}
-		if oldestOutput.IsZero() || info.ModTime().Before(oldestOutput) {
-			oldestOutput = info.ModTime()
+		r.emit(Event{Type: EventCommandExited, Task: task.Name, Command: cmdText, Attempt: attempt, ExitCode: &code, DurationMS: durationMS(start)})
+		if err != nil {
+			return r.commandError(ctx, task, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) commandError(ctx context.Context, task *remfile.Task, err error) error {
+	if task.Timeout > 0 && errors.Is(context.Cause(ctx), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", task.Timeout)
+	}
+	return err
+}
+
+func (r *Runner) taskCommands(t *remfile.Task) []string {
+	out := make([]string, 0, len(t.Cmds))
+	for _, rawCmd := range t.Cmds {
+		cmdText := strings.TrimSpace(r.File.ExpandTask(t, rawCmd))
+		if cmdText != "" {
+			out = append(out, cmdText)
 		}
 	}
+	return out
+}
+
+func (r *Runner) taskDir(t *remfile.Task) string {
+	base := r.File.TaskFile(t).Dir
+	taskDir := r.File.ExpandTask(t, t.Dir)
+	if taskDir == "" {
+		return base
+	}
+	if filepath.IsAbs(taskDir) {
+		return taskDir
+	}
+	return filepath.Join(base, taskDir)
+}
+
+func (r *Runner) isUpToDate(t *remfile.Task) (bool, string, *fingerprint, error) {
+	if positive, _ := declaredOutputs(r.File.ExpandTaskList(t, t.Outputs)); len(positive) == 0 {
+		return false, "no outputs", nil, nil
+	}
+
+	hashInputs := t.Check != remfile.CheckMtime
+	fp, err := r.fingerprintTask(t, hashInputs)
+	if err != nil {
+		return false, "", nil, err
+	}
 
+	outputs, err := r.resolveOutputs(t)
+	if err != nil {
+		return false, "", nil, err
+	}
+	if len(outputs.missing) > 0 {
+		return false, "missing output " + outputs.missing[0], fp, nil
+	}
+
+	rec := r.state.get(t.Name)
+	if rec == nil {
+		return false, "no previous run", fp, nil
+	}
+	if reason := r.changeReason(t, rec, fp); reason != "" {
+		return false, reason, fp, nil
+	}
+	if reason, err := r.discoveredChange(rec, hashInputs, outputs.oldest); err != nil || reason != "" {
+		return false, reason, fp, err
+	}
+	if hashInputs {
+		return true, "fingerprint unchanged", fp, nil
+	}
+
+	upToDate, reason, err := r.isUpToDateMtime(t, outputs.oldest)
+	return upToDate, reason, fp, err
+}
+
+func (r *Runner) isUpToDateMtime(t *remfile.Task, oldestOutput time.Time) (bool, string, error) {
+	inputs := r.File.ExpandTaskList(t, t.Inputs)
 	if len(inputs) == 0 {
 		return true, "outputs exist", nil
 	}
 
+	files, _, err := r.resolvePatterns(r.File.TaskFile(t).Dir, inputs)
+	if err != nil {
+		return false, "", err
+	}
 	newestInput := time.Time{}
-	for _, in := range inputs {
-		paths, err := resolveInputPaths(r.File.Dir, in)
+	for _, f := range files {
+		info, err := os.Stat(f.Path)
 		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
 			return false, "", err
 		}
-		for _, p := range paths {
-			info, err := os.Stat(p)
-			if err != nil {
-				if os.IsNotExist(err) {
-					continue
-				}
-				return false, "", err
-			}
-			if info.ModTime().After(newestInput) {
-				newestInput = info.ModTime()
-			}
+		if info.ModTime().After(newestInput) {
+			newestInput = info.ModTime()
 		}
 	}
 
@@ -285,30 +698,85 @@ func (r *Runner) isUpToDate(t *remfile.Task) (bool, string, error) {
 	return true, "outputs newer than inputs", nil
 }
 
-func resolveInputPaths(baseDir, value string) ([]string, error) {
-	full := value
-	if !filepath.IsAbs(full) {
-		full = filepath.Join(baseDir, value)
+// commandsOwnTerminal reports whether task commands get rem's terminal while
+// they run: only when it is the terminal and one command runs at a time.
+// Watch mode services run beside other tasks, so they never do.
+func (r *Runner) commandsOwnTerminal() bool {
+	return r.jobs() == 1 && r.services == nil && terminalStdin()
+}
+
+func (r *Runner) jobs() int {
+	if r.Jobs < 1 {
+		return runtime.NumCPU()
 	}
+	return r.Jobs
+}
 
-	if hasGlob(full) {
-		matches, err := filepath.Glob(full)
-		if err != nil {
-			return nil, err
+// shellCommand returns the command for cmdText and a func to call once it
+// has been waited for, which reports whether the command was interrupted
+// from the terminal.
+func (r *Runner) shellCommand(ctx context.Context, cmdText string, foreground bool) (*exec.Cmd, func() bool) {
+	bin, prefix, _ := shellcfg.ResolveTaskShell()
+	args := append(prefix, cmdText)
+	cmd := exec.CommandContext(ctx, bin, args...)
+	release := configureProcess(cmd, func() os.Signal {
+		if sig := interruptSignal(ctx); sig != nil {
+			return sig
 		}
-		return matches, nil
-	}
-	return []string{full}, nil
+		return syscall.SIGTERM
+	}, r.gracePeriod(), foreground)
+	return cmd, release
 }
 
-func hasGlob(p string) bool {
-	return strings.ContainsAny(p, "*?[")
+func (r *Runner) resolveTargets(targets []string) ([]string, error) {
+	if len(targets) == 0 {
+		targets = []string{""}
+	}
+	out := make([]string, 0, len(targets))
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if target == "" {
+			target = r.File.DefaultTarget()
+		}
+		target = r.File.ExpandString(target)
+		if _, ok := r.File.Tasks[target]; !ok {
+			return nil, r.File.NoSuchTask("target", target)
+		}
+		if !seen[target] {
+			seen[target] = true
+			out = append(out, target)
+		}
+	}
+	return out, nil
 }
 
-func shellCommand(ctx context.Context, cmdText string) *exec.Cmd {
-	bin, prefix, _ := shellcfg.ResolveTaskShell()
-	args := append(prefix, cmdText)
-	return exec.CommandContext(ctx, bin, args...)
+// collectTargets returns the union of the targets' subsets. With Ordered
+// set, every task first needed by a target waits for all earlier targets,
+// so targets finish left to right while shared deps still run once. Waiting
+// on the previous target alone is not enough when it was already pulled in
+// as a dep of an earlier one and finished first.
+func (r *Runner) collectTargets(targets []string) (map[string]bool, map[string][]string, error) {
+	subset := make(map[string]bool)
+	var after map[string][]string
+	if r.Ordered {
+		after = make(map[string][]string)
+	}
+	for i, target := range targets {
+		sub, err := r.collectSubset(target)
+		if err != nil {
+			return nil, nil, err
+		}
+		for name := range sub {
+			if subset[name] {
+				continue
+			}
+			subset[name] = true
+			if r.Ordered {
+				after[name] = append(after[name], targets[:i]...)
+			}
+		}
+	}
+	return subset, after, nil
 }
 
 func (r *Runner) collectSubset(target string) (map[string]bool, error) {
@@ -332,7 +800,7 @@ func (r *Runner) collectSubset(target string) (map[string]bool, error) {
 		vis[name] = 1
 		stack = append(stack, name)
 		subset[name] = true
-		for _, dep := range r.File.ExpandList(t.Deps) {
+		for _, dep := range r.File.ExpandTaskList(t, t.Deps) {
 			if err := dfs(dep); err != nil {
 				return err
 			}
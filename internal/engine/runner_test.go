@@ -1,9 +1,16 @@
 package engine
 
 import (
+	"bytes"
+	"errors"
 	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"rem/internal/cache"
 	"rem/internal/remfile"
 )
 
@@ -28,3 +35,854 @@ func TestCycleDetection(t *testing.T) {
 		t.Fatalf("expected cycle error, got nil")
 	}
 }
+
+func TestHashCheckIgnoresTouchAndTracksContent(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+	if err := os.WriteFile(in, []byte("one"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "build",
+		Order:   []string{"build"},
+		Tasks: map[string]*remfile.Task{
+			"build": {
+				Name:    "build",
+				Inputs:  []string{"in.txt"},
+				Outputs: []string{"out.txt"},
+				Cmds:    []string{"cat in.txt > out.txt"},
+			},
+		},
+	}
+
+	run := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard}
+		if err := r.Run("build"); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return out.String()
+	}
+
+	if got := run(); !strings.Contains(got, "[run] build") {
+		t.Fatalf("first run should execute, got:\n%s", got)
+	}
+	if got := run(); !strings.Contains(got, "[skip] build") {
+		t.Fatalf("second run should skip, got:\n%s", got)
+	}
+
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(in, future, future); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !strings.Contains(got, "[skip] build") {
+		t.Fatalf("touched input should not rebuild, got:\n%s", got)
+	}
+
+	if err := os.WriteFile(in, []byte("two"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !strings.Contains(got, "[run] build") {
+		t.Fatalf("changed input should rebuild, got:\n%s", got)
+	}
+}
+
+func TestVarOverrideInvalidatesTask(t *testing.T) {
+	dir := t.TempDir()
+	content := `
+default = "build"
+
+[vars]
+VERSION = "dev"
+LDFLAGS = "-X main.version=${VERSION}"
+
+[task.build]
+uptodate = "mtime"
+outputs = ["out.txt"]
+cmds = ["echo ${LDFLAGS} > out.txt"]
+`
+	path := filepath.Join(dir, "Remfile")
+	if err := os.WriteFile(path, []byte(strings.TrimSpace(content)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(overrides map[string]string) string {
+		t.Helper()
+		rf, err := remfile.Load(path)
+		if err != nil {
+			t.Fatalf("Load() error: %v", err)
+		}
+		if err := rf.ApplyOverrides(overrides); err != nil {
+			t.Fatalf("ApplyOverrides() error: %v", err)
+		}
+		var out bytes.Buffer
+		r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard}
+		if err := r.Run("build"); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return out.String()
+	}
+
+	run(nil)
+	if got := run(nil); !strings.Contains(got, "[skip] build") {
+		t.Fatalf("unchanged run should skip, got:\n%s", got)
+	}
+	if got := run(map[string]string{"VERSION": "v1.2.3"}); !strings.Contains(got, "[run] build (var VERSION changed)") {
+		t.Fatalf("override should rebuild with var reason, got:\n%s", got)
+	}
+}
+
+func TestOutputsRestoredFromCache(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.txt")
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "build",
+		Order:   []string{"build"},
+		Tasks: map[string]*remfile.Task{
+			"build": {
+				Name:    "build",
+				Inputs:  []string{"in.txt"},
+				Outputs: []string{"out.txt"},
+				Cmds:    []string{"cat in.txt > out.txt"},
+			},
+		},
+	}
+	store := &cache.Local{Dir: t.TempDir()}
+
+	run := func(content string) string {
+		t.Helper()
+		if err := os.WriteFile(in, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard, Cache: store}
+		if err := r.Run("build"); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return out.String()
+	}
+
+	run("one")
+	run("two")
+	if got := run("one"); !strings.Contains(got, "[cache] build") {
+		t.Fatalf("expected cache restore, got:\n%s", got)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "one" {
+		t.Fatalf("restored output = %q, want one", got)
+	}
+}
+
+func TestSymlinkOutputRestoredOrRebuilt(t *testing.T) {
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "build",
+		Order:   []string{"build"},
+		Tasks: map[string]*remfile.Task{
+			"build": {Name: "build", Outputs: []string{"link"}, Cmds: []string{"echo built > real && ln -sf real link"}},
+		},
+	}
+	store := &cache.Local{Dir: t.TempDir()}
+	run := func(remove ...string) string {
+		t.Helper()
+		for _, name := range remove {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var out bytes.Buffer
+		r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard, Cache: store}
+		if err := r.Run("build"); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "link")); err != nil {
+			t.Fatalf("link missing after run: %v\n%s", err, out.String())
+		}
+		return out.String()
+	}
+
+	run()
+	if got := run("link"); !strings.Contains(got, "[cache] build") {
+		t.Fatalf("expected the link to be restored from cache, got:\n%s", got)
+	}
+	// The entry holds only the link, so restoring it leaves a dangling link.
+	if got := run("link", "real"); !strings.Contains(got, "[run] build") {
+		t.Fatalf("expected a rebuild when the restored outputs are incomplete, got:\n%s", got)
+	}
+}
+
+func TestCacheKeyIncludesPlatform(t *testing.T) {
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "build",
+		Order:   []string{"build"},
+		Tasks: map[string]*remfile.Task{
+			"build": {Name: "build", Outputs: []string{"out.txt"}, Cmds: []string{"echo built > out.txt"}},
+		},
+	}
+	store := &cache.Local{Dir: t.TempDir()}
+
+	run := func() string {
+		t.Helper()
+		if err := os.RemoveAll(filepath.Join(dir, ".rem")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Remove(filepath.Join(dir, "out.txt")); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard, Cache: store}
+		if err := r.Run("build"); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return out.String()
+	}
+
+	run()
+	if got := run(); !strings.Contains(got, "[cache] build") {
+		t.Fatalf("expected cache restore on the same platform, got:\n%s", got)
+	}
+	defer func(p string) { cachePlatform = p }(cachePlatform)
+	cachePlatform = "plan9/mips"
+	if got := run(); strings.Contains(got, "[cache] build") {
+		t.Fatalf("outputs of another platform were restored:\n%s", got)
+	}
+}
+
+func TestKeepGoingRunsIndependentTasks(t *testing.T) {
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "all",
+		Order:   []string{"broken", "after", "other", "all"},
+		Tasks: map[string]*remfile.Task{
+			"broken": {Name: "broken", Cmds: []string{"exit 1"}},
+			"after":  {Name: "after", Deps: []string{"broken"}, Cmds: []string{"touch after.txt"}},
+			"other":  {Name: "other", Cmds: []string{"touch other.txt"}},
+			"all":    {Name: "all", Deps: []string{"after", "other"}},
+		},
+	}
+
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	err := r.Run("all")
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("expected *RunError, got %v", err)
+	}
+	if len(runErr.Failed) != 1 || runErr.Failed[0].Task != "broken" {
+		t.Fatalf("failed = %#v", runErr.Failed)
+	}
+	if len(runErr.Blocked) != 2 || runErr.Blocked[0].Task != "after" || runErr.Blocked[1].Task != "all" {
+		t.Fatalf("blocked = %#v", runErr.Blocked)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "other.txt")); err != nil {
+		t.Fatalf("independent task should have run: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "after.txt")); err == nil {
+		t.Fatalf("dependent of failed task must not run")
+	}
+}
+
+func TestFailFastCancelsInFlightTasks(t *testing.T) {
+	rf := &remfile.File{
+		Dir:     t.TempDir(),
+		Default: "all",
+		Order:   []string{"slow", "broken", "all"},
+		Tasks: map[string]*remfile.Task{
+			"slow":   {Name: "slow", Cmds: []string{"sleep 10"}},
+			"broken": {Name: "broken", Cmds: []string{"sleep 0.2; exit 1"}},
+			"all":    {Name: "all", Deps: []string{"slow", "broken"}},
+		},
+	}
+
+	r := &Runner{File: rf, Jobs: 2, Stdout: io.Discard, Stderr: io.Discard, FailFast: true}
+	start := time.Now()
+	err := r.Run("all")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("fail-fast run took %v, in-flight task was not canceled", elapsed)
+	}
+	var runErr *RunError
+	if !errors.As(err, &runErr) {
+		t.Fatalf("expected *RunError, got %v", err)
+	}
+	if len(runErr.Failed) != 1 || runErr.Failed[0].Task != "broken" {
+		t.Fatalf("failed = %#v", runErr.Failed)
+	}
+	if len(runErr.Canceled) != 2 {
+		t.Fatalf("canceled = %#v, want slow and all", runErr.Canceled)
+	}
+}
+
+func TestRetriesRerunFailedTask(t *testing.T) {
+	rf := &remfile.File{
+		Dir:     t.TempDir(),
+		Default: "flaky",
+		Order:   []string{"flaky"},
+		Tasks: map[string]*remfile.Task{
+			"flaky": {
+				Name:    "flaky",
+				Retries: 2,
+				Cmds:    []string{"if [ -f marker ]; then exit 0; fi; touch marker; exit 1"},
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard}
+	if err := r.Run("flaky"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !strings.Contains(out.String(), "[retry] flaky (attempt 1/3 failed") {
+		t.Fatalf("missing retry report, got:\n%s", out.String())
+	}
+}
+
+func TestTimeoutStopsTask(t *testing.T) {
+	rf := &remfile.File{
+		Dir:     t.TempDir(),
+		Default: "hang",
+		Order:   []string{"hang"},
+		Tasks: map[string]*remfile.Task{
+			"hang": {Name: "hang", Timeout: 200 * time.Millisecond, Cmds: []string{"sleep 10"}},
+		},
+	}
+
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	start := time.Now()
+	err := r.Run("hang")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("timeout not enforced, took %v", elapsed)
+	}
+}
+
+func TestDryRunPrintsPlanWithoutExecuting(t *testing.T) {
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "build",
+		Vars:    map[string]string{"APP": "rem"},
+		Order:   []string{"build", "gen"},
+		Tasks: map[string]*remfile.Task{
+			"gen":   {Name: "gen", Cmds: []string{"touch gen.txt"}},
+			"build": {Name: "build", Deps: []string{"gen"}, Dir: "src", Cmds: []string{"touch ${APP}.txt"}},
+		},
+	}
+
+	var out bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard, DryRun: true}
+	if err := r.Run("build"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+
+	got := out.String()
+	gen := strings.Index(got, "[would run] gen")
+	build := strings.Index(got, "[would run] build")
+	if gen < 0 || build < 0 || gen > build {
+		t.Fatalf("unexpected plan order:\n%s", got)
+	}
+	if !strings.Contains(got, "$ touch rem.txt") || !strings.Contains(got, filepath.Join(dir, "src")) {
+		t.Fatalf("plan missing expanded command or dir:\n%s", got)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "gen.txt")); err == nil {
+		t.Fatalf("dry run must not execute commands")
+	}
+}
+
+func TestDirectoryAndGlobOutputs(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "page.md")
+	if err := os.WriteFile(in, []byte("# page"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "site",
+		Order:   []string{"site"},
+		Tasks: map[string]*remfile.Task{
+			"site": {
+				Name:    "site",
+				Check:   remfile.CheckMtime,
+				Inputs:  []string{"page.md"},
+				Outputs: []string{"site", "dist/*.txt"},
+				Cmds:    []string{"mkdir -p site/css dist && cp page.md site/index.html && touch site/css/a.css dist/notes.txt"},
+			},
+		},
+	}
+
+	run := func() string {
+		t.Helper()
+		var out bytes.Buffer
+		r := &Runner{File: rf, Jobs: 1, Stdout: &out, Stderr: io.Discard}
+		if err := r.Run("site"); err != nil {
+			t.Fatalf("Run() error: %v", err)
+		}
+		return out.String()
+	}
+
+	run()
+	if got := run(); !strings.Contains(got, "[skip] site") {
+		t.Fatalf("second run should skip, got:\n%s", got)
+	}
+
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "site", "css", "a.css"), past, past); err != nil {
+		t.Fatal(err)
+	}
+	if got := run(); !strings.Contains(got, "[run] site (input newer than output)") {
+		t.Fatalf("stale file inside output dir should rebuild, got:\n%s", got)
+	}
+}
+
+func TestMissingDeclaredOutputFailsTask(t *testing.T) {
+	rf := &remfile.File{
+		Dir:     t.TempDir(),
+		Default: "build",
+		Order:   []string{"build"},
+		Tasks: map[string]*remfile.Task{
+			"build": {Name: "build", Outputs: []string{"bin/app", "dist/*.tar.gz"}, Cmds: []string{"mkdir -p bin && touch bin/ap"}},
+		},
+	}
+
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	err := r.Run("build")
+	if err == nil || !strings.Contains(err.Error(), "declared outputs were not produced: bin/app, dist/*.tar.gz") {
+		t.Fatalf("expected missing output error, got %v", err)
+	}
+}
+
+func TestStaleDeclaredOutputFailsOrWarns(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "out.txt")
+	if err := os.WriteFile(stale, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "build",
+		Order:   []string{"build"},
+		Tasks: map[string]*remfile.Task{
+			"build": {Name: "build", Outputs: []string{"out.txt"}, Cmds: []string{"true"}},
+		},
+	}
+
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	err := r.Run("build")
+	if err == nil || !strings.Contains(err.Error(), "declared outputs were not updated by this run: out.txt") {
+		t.Fatalf("expected stale output error, got %v", err)
+	}
+
+	var stderr bytes.Buffer
+	rf.Tasks["build"].Verify = remfile.VerifyWarn
+	r = &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: &stderr}
+	if err := r.Run("build"); err != nil {
+		t.Fatalf("warn mode should not fail: %v", err)
+	}
+	if !strings.Contains(stderr.String(), "[warn] build: declared outputs were not updated by this run: out.txt") {
+		t.Fatalf("expected warning, got %q", stderr.String())
+	}
+}
+
+func TestDepfileInputsAreTracked(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"foo.c": "int main;", "foo.h": "#define X 1"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "obj",
+		Order:   []string{"obj"},
+		Tasks: map[string]*remfile.Task{
+			"obj": {
+				Name:    "obj",
+				Inputs:  []string{"foo.c"},
+				Outputs: []string{"build/foo.o"},
+				Depfile: "build/foo.d",
+				Cmds:    []string{"mkdir -p build && cat foo.c foo.h > build/foo.o && printf 'build/foo.o: foo.c \\\\\\n foo.h\\n' > build/foo.d"},
+			},
+		},
+	}
+
+	var stdout bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: &stdout, Stderr: io.Discard}
+	if err := r.Run("obj"); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	stdout.Reset()
+	if err := r.Run("obj"); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "[skip] obj") {
+		t.Fatalf("expected skip, got %q", stdout.String())
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "foo.h"), []byte("#define X 2"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := r.Run("obj"); err != nil {
+		t.Fatalf("third run: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "[run] obj (discovered input foo.h changed)") {
+		t.Fatalf("expected rebuild for header change, got %q", stdout.String())
+	}
+}
+
+func TestTaskParamChangeInvalidatesTask(t *testing.T) {
+	rf := &remfile.File{
+		Dir:     t.TempDir(),
+		Default: "pkg",
+		Order:   []string{"pkg"},
+		Tasks: map[string]*remfile.Task{
+			"pkg": {
+				Name:    "pkg",
+				Params:  []remfile.Param{{Name: "target", Default: "linux"}},
+				Outputs: []string{"out.txt"},
+				Cmds:    []string{"echo ${target} ${ARGS} > out.txt"},
+			},
+		},
+	}
+
+	var stdout bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: &stdout, Stderr: io.Discard}
+	if err := r.Run("pkg"); err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+
+	if err := rf.SetParams("pkg", map[string]string{"target": "darwin"}, []string{"-v"}); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	if err := r.Run("pkg"); err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "[run] pkg (vars ARGS, target changed)") {
+		t.Fatalf("expected rebuild for param change, got %q", stdout.String())
+	}
+	raw, err := os.ReadFile(filepath.Join(rf.Dir, "out.txt"))
+	if err != nil || string(raw) != "darwin -v\n" {
+		t.Fatalf("out.txt = %q, %v", raw, err)
+	}
+}
+
+func TestRunMultipleTargetsSharesDeps(t *testing.T) {
+	for _, ordered := range []bool{false, true} {
+		dir := t.TempDir()
+		rf := &remfile.File{
+			Dir:     dir,
+			Default: "build",
+			Order:   []string{"gen", "lint", "test", "build"},
+			Tasks: map[string]*remfile.Task{
+				"gen":   {Name: "gen", Cmds: []string{"echo gen >> log"}},
+				"lint":  {Name: "lint", Deps: []string{"gen"}, Cmds: []string{"sleep 0.2; echo lint >> log"}},
+				"test":  {Name: "test", Deps: []string{"gen"}, Cmds: []string{"echo test >> log"}},
+				"build": {Name: "build", Cmds: []string{"echo build >> log"}},
+			},
+		}
+
+		r := &Runner{File: rf, Jobs: 4, Stdout: io.Discard, Stderr: io.Discard, Ordered: ordered}
+		if err := r.Run("lint", "build", "test", "lint"); err != nil {
+			t.Fatalf("ordered=%v: Run() error: %v", ordered, err)
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, "log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Fields(string(raw))
+		if strings.Count(string(raw), "gen") != 1 || len(lines) != 4 {
+			t.Fatalf("ordered=%v: log = %q, want every task once", ordered, lines)
+		}
+		if ordered && strings.Join(lines, " ") != "gen lint build test" {
+			t.Fatalf("ordered run log = %q, want targets left to right", lines)
+		}
+	}
+}
+
+func TestOrderedRunWaitsForEveryEarlierTarget(t *testing.T) {
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "a",
+		Order:   []string{"a", "b", "c"},
+		Tasks: map[string]*remfile.Task{
+			"a": {Name: "a", Deps: []string{"c"}, Cmds: []string{"sleep 0.3; echo a >> log"}},
+			"b": {Name: "b", Cmds: []string{"echo b >> log"}},
+			"c": {Name: "c", Cmds: []string{"echo c >> log"}},
+		},
+	}
+
+	r := &Runner{File: rf, Jobs: 4, Stdout: io.Discard, Stderr: io.Discard, Ordered: true}
+	if err := r.Run("a", "c", "b"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(strings.Fields(string(raw)), " "); got != "c a b" {
+		t.Fatalf("ordered run log = %q, want %q", got, "c a b")
+	}
+}
+
+func TestTaskEnvironment(t *testing.T) {
+	t.Setenv("REM_TEST_SECRET", "s3cret")
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "show",
+		Order:   []string{"show"},
+		Vars:    map[string]string{"ARCH": "amd64"},
+		Env:     []remfile.EnvVar{{Name: "GOOS", Value: "linux"}, {Name: "GOARCH", Value: "${ARCH}"}},
+		Tasks: map[string]*remfile.Task{
+			"show": {
+				Name:    "show",
+				Env:     []remfile.EnvVar{{Name: "GOOS", Value: "windows"}},
+				Outputs: []string{"env.txt"},
+				Cmds:    []string{`echo "$GOOS/$GOARCH/$REM_TEST_SECRET" > env.txt`},
+			},
+		},
+	}
+	read := func() string {
+		raw, err := os.ReadFile(filepath.Join(dir, "env.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.TrimSpace(string(raw))
+	}
+
+	var stdout bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: &stdout, Stderr: io.Discard}
+	if err := r.Run("show"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := read(); got != "windows/amd64/s3cret" {
+		t.Fatalf("env = %q", got)
+	}
+
+	rf.Tasks["show"].Env = nil
+	rf.CleanEnv = true
+	stdout.Reset()
+	if err := r.Run("show"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "[run] show (env GOOS changed)") {
+		t.Fatalf("expected rebuild for env change, got %q", stdout.String())
+	}
+	if got := read(); got != "linux/amd64/" {
+		t.Fatalf("clean env = %q", got)
+	}
+
+	rf.KeepEnv = []string{"REM_TEST_*"}
+	rf.Env = rf.Env[:1]
+	if err := r.Run("show"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if got := read(); got != "linux//s3cret" {
+		t.Fatalf("kept env = %q", got)
+	}
+}
+
+func TestDotenvFeedsCommandEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("REM_TEST_DB=local\nREM_TEST_SHADOWED=dotenv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	remfilePath := filepath.Join(dir, "Remfile")
+	content := "default = \"show\"\ndotenv = [\".env\", \".env.local\"]\n\n[task.show]\ncmds = [\"echo $REM_TEST_DB/$REM_TEST_SHADOWED > env.txt\"]\n"
+	if err := os.WriteFile(remfilePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REM_TEST_SHADOWED", "os")
+
+	rf, err := remfile.Load(remfilePath)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	if err := r.Run(); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "env.txt"))
+	if err != nil || strings.TrimSpace(string(raw)) != "local/os" {
+		t.Fatalf("env.txt = %q, %v", raw, err)
+	}
+}
+
+func TestStateKeepsNoEnvValues(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, ".env"), []byte("API_TOKEN=s3cr3t-dotenv\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	remfilePath := filepath.Join(dir, "Remfile")
+	content := "dotenv = [\".env\"]\n\n[task.deploy]\nenv = { TOKEN = \"${REM_TEST_GITHUB_TOKEN}\" }\noutputs = [\"out.txt\"]\ncmd = \"touch out.txt\"\n"
+	if err := os.WriteFile(remfilePath, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("REM_TEST_GITHUB_TOKEN", "s3cr3t-os")
+
+	rf, err := remfile.Load(remfilePath)
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	if err := r.Run("deploy"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, stateDirName, stateFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "s3cr3t") {
+		t.Fatalf(".rem/state holds an env value:\n%s", raw)
+	}
+
+	var stdout bytes.Buffer
+	t.Setenv("REM_TEST_GITHUB_TOKEN", "rotated")
+	r = &Runner{File: rf, Jobs: 1, Stdout: &stdout, Stderr: io.Discard}
+	if err := r.Run("deploy"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "(env TOKEN changed)") {
+		t.Fatalf("expected a rebuild for the changed env value, got %q", stdout.String())
+	}
+}
+
+func TestStateKeepsNoVarValues(t *testing.T) {
+	t.Setenv("REM_TEST_TOKEN", "s3cr3t-os")
+	dir := t.TempDir()
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "deploy",
+		Order:   []string{"deploy"},
+		Vars:    map[string]string{"AUTH": "Bearer s3cr3t-var"},
+		RawVars: map[string]string{"AUTH": "Bearer s3cr3t-var"},
+		Tasks: map[string]*remfile.Task{
+			"deploy": {Name: "deploy", Outputs: []string{"out.txt"}, Cmds: []string{"echo ${AUTH} ${REM_TEST_TOKEN} > /dev/null; touch out.txt"}},
+		},
+	}
+	var stdout bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: &stdout, Stderr: io.Discard}
+	if err := r.Run("deploy"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, stateDirName, stateFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "s3cr3t") {
+		t.Fatalf(".rem/state holds an expanded value:\n%s", raw)
+	}
+
+	rf.Vars["AUTH"], rf.RawVars["AUTH"] = "Bearer rotated", "Bearer rotated"
+	stdout.Reset()
+	if err := r.Run("deploy"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "(var AUTH changed)") {
+		t.Fatalf("expected a rebuild for the changed var, got %q", stdout.String())
+	}
+}
+
+func TestIncludedRemfileCleansItsOwnEnv(t *testing.T) {
+	t.Setenv("REM_TEST_KEEP", "kept")
+	t.Setenv("REM_TEST_DROP", "dropped")
+	dir := t.TempDir()
+	files := map[string]string{
+		"Remfile":     "includes = { api = \"api/Remfile\" }\n\n[task.show]\ncmd = \"echo \\\"$REM_TEST_KEEP/$REM_TEST_DROP\\\" > env.txt\"\n",
+		"api/Remfile": "clean_env = true\nkeep_env = [\"REM_TEST_KEEP\"]\n\n[task.show]\ncmd = \"echo \\\"$REM_TEST_KEEP/$REM_TEST_DROP\\\" > env.txt\"\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf, err := remfile.Load(filepath.Join(dir, "Remfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard}
+	for _, name := range []string{"show", "api:show"} {
+		if err := r.Run(name); err != nil {
+			t.Fatalf("Run(%s) error: %v", name, err)
+		}
+	}
+	for path, want := range map[string]string{"env.txt": "kept/dropped", "api/env.txt": "kept/"} {
+		raw, err := os.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.TrimSpace(string(raw)); got != want {
+			t.Fatalf("%s = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestIncludedTasksRunInTheirOwnDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"Remfile":              "includes = { api = \"services/api/Remfile\" }\n\n[task.all]\ndeps = [\"api:build\"]\ncmd = \"cat services/api/out.txt > all.txt\"\n",
+		"services/api/Remfile": "[vars]\nNAME = \"api\"\n\n[task.build]\ninputs = [\"src.txt\"]\noutputs = [\"out.txt\"]\ncmd = \"cat src.txt > out.txt && echo ${NAME} >> out.txt\"\n",
+		"services/api/src.txt": "source\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf, err := remfile.Load(filepath.Join(dir, "Remfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout bytes.Buffer
+	r := &Runner{File: rf, Jobs: 1, Stdout: &stdout, Stderr: io.Discard}
+	if err := r.Run("all"); err != nil {
+		t.Fatalf("Run() error: %v\n%s", err, stdout.String())
+	}
+	raw, err := os.ReadFile(filepath.Join(dir, "all.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(raw); got != "source\napi\n" {
+		t.Fatalf("all.txt = %q", got)
+	}
+
+	stdout.Reset()
+	if err := r.Run("api:build"); err != nil {
+		t.Fatalf("Run() error: %v", err)
+	}
+	if !strings.Contains(stdout.String(), "[skip] api:build") {
+		t.Fatalf("second run should skip, got:\n%s", stdout.String())
+	}
+}
@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	stateDirName  = ".rem"
+	stateFileName = "state"
+	stateVersion  = 2
+)
+
+type taskRecord struct {
+	Fingerprint string            `json:"fingerprint"`
+	Inputs      map[string]string `json:"inputs,omitempty"`
+	Cmds        []string          `json:"cmds,omitempty"`
+	Dir         string            `json:"dir,omitempty"`
+	Outputs     []string          `json:"outputs,omitempty"`
+	Depfile     string            `json:"depfile,omitempty"`
+	Discovered  map[string]string `json:"discovered,omitempty"`
+	Vars        map[string]string `json:"vars,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Time        time.Time         `json:"time"`
+}
+
+type stateDB struct {
+	path  string
+	mu    sync.Mutex
+	tasks map[string]*taskRecord
+}
+
+type stateFile struct {
+	Version int                    `json:"version"`
+	Tasks   map[string]*taskRecord `json:"tasks"`
+}
+
+func loadState(baseDir string) (*stateDB, error) {
+	db := &stateDB{
+		path:  filepath.Join(baseDir, stateDirName, stateFileName),
+		tasks: make(map[string]*taskRecord),
+	}
+
+	raw, err := os.ReadFile(db.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return db, nil
+		}
+		return nil, err
+	}
+
+	var sf stateFile
+	if err := json.Unmarshal(raw, &sf); err != nil || sf.Version != stateVersion {
+		// A corrupt or outdated state only costs a rebuild.
+		return db, nil
+	}
+	for name, rec := range sf.Tasks {
+		if rec != nil {
+			db.tasks[name] = rec
+		}
+	}
+	return db, nil
+}
+
+func (db *stateDB) get(name string) *taskRecord {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.tasks[name]
+}
+
+func (db *stateDB) put(name string, rec *taskRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.tasks[name] = rec
+	return db.saveLocked()
+}
+
+func (db *stateDB) saveLocked() error {
+	raw, err := json.MarshalIndent(stateFile{Version: stateVersion, Tasks: db.tasks}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(db.path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(db.path), stateFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(raw, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), db.path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,493 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"rem/internal/remfile"
+)
+
+const (
+	defaultWatchDebounce = 200 * time.Millisecond
+	defaultWatchInterval = 500 * time.Millisecond
+)
+
+// watcher wakes the watch loop when something below the watched
+// directories may have changed. The loop itself decides what changed by
+// comparing input snapshots, so spurious wake-ups are harmless.
+type watcher interface {
+	watch(dirs []string) error
+	changes() <-chan struct{}
+	close() error
+}
+
+type pollWatcher struct {
+	ch   chan struct{}
+	stop chan struct{}
+}
+
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{ch: make(chan struct{}, 1), stop: make(chan struct{})}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				select {
+				case w.ch <- struct{}{}:
+				default:
+				}
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+func (w *pollWatcher) watch([]string) error     { return nil }
+func (w *pollWatcher) changes() <-chan struct{} { return w.ch }
+
+func (w *pollWatcher) close() error {
+	close(w.stop)
+	return nil
+}
+
+type fileStamp struct {
+	size int64
+	mod  time.Time
+}
+
+type inputSnapshot map[string]map[string]fileStamp
+
+// Watch runs targets, then watches the inputs of every task in their subset
+// and re-runs the tasks affected by each change (and their dependents)
+// until ctx is canceled or the process is interrupted. A change that
+// arrives while a run is in flight cancels it and starts over. Tasks with
+// `restart = true` are started in the background and only restarted when
+// they are affected.
+func (r *Runner) Watch(ctx context.Context, targets ...string) error {
+	if r.File == nil {
+		return fmt.Errorf("runner has no loaded Remfile")
+	}
+	if r.Stdout == nil {
+		r.Stdout = os.Stdout
+	}
+	if r.Stderr == nil {
+		r.Stderr = os.Stderr
+	}
+	targets, err := r.resolveTargets(targets)
+	if err != nil {
+		return err
+	}
+	subset, after, err := r.collectTargets(targets)
+	if err != nil {
+		return err
+	}
+	dependents, _, _ := r.buildGraph(subset, after)
+	outputs := r.outputRules(subset)
+
+	ctx, cancelCause := context.WithCancelCause(ctx)
+	defer cancelCause(nil)
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, interruptSignals...)
+	defer signal.Stop(sigCh)
+	go func() {
+		select {
+		case sig := <-sigCh:
+			cancelCause(interruptCause{sig: sig})
+		case <-ctx.Done():
+		}
+	}()
+
+	w := r.newWatcher()
+	defer func() { w.close() }()
+	rewatch := func() {
+		dirs, err := r.watchDirs(subset)
+		if err == nil {
+			err = w.watch(dirs)
+		}
+		if err != nil {
+			if _, polling := w.(*pollWatcher); !polling {
+				fmt.Fprintf(r.Stderr, "%s %v; falling back to polling\n", r.paint("33", "[watch]"), err)
+				w.close()
+				w = newPollWatcher(r.watchInterval())
+			}
+		}
+	}
+	rewatch()
+
+	snap, err := r.inputSnapshot(subset, outputs)
+	if err != nil {
+		return err
+	}
+
+	r.services = &serviceSet{ctx: ctx, procs: make(map[string]*service)}
+	defer func() {
+		r.services.stopAll()
+		r.services = nil
+	}()
+
+	pending := make(map[string]bool, len(subset))
+	for name := range subset {
+		pending[name] = true
+	}
+	stale := make(map[string]bool)
+	var (
+		runCancel context.CancelFunc
+		runDone   chan error
+	)
+	start := func() {
+		only := make(map[string]bool, len(pending)+len(stale))
+		for name := range pending {
+			only[name] = true
+		}
+		for name := range stale {
+			only[name] = true
+		}
+		pending = make(map[string]bool)
+		stale = make(map[string]bool)
+
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		runCancel, runDone = cancel, done
+		go func() { done <- r.run(runCtx, targets, only) }()
+	}
+	// collect remembers tasks that did not finish so the next run picks
+	// them up again.
+	collect := func(err error) {
+		runCancel()
+		runDone = nil
+		var runErr *RunError
+		if !errors.As(err, &runErr) {
+			if err != nil {
+				fmt.Fprintf(r.Stderr, "%s %v\n", r.paint("31", "[watch]"), err)
+			}
+			return
+		}
+		for _, f := range runErr.Failed {
+			stale[f.Task] = true
+		}
+		for _, b := range runErr.Blocked {
+			stale[b.Task] = true
+		}
+		for _, name := range append(runErr.Canceled, runErr.Interrupted...) {
+			stale[name] = true
+		}
+	}
+
+	start()
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			if runDone != nil {
+				runCancel()
+				<-runDone
+			}
+			if sig := interruptSignal(ctx); sig != nil {
+				return &RunError{Signal: sig}
+			}
+			return nil
+
+		case <-w.changes():
+			debounce = time.After(r.watchDebounce())
+
+		case <-debounce:
+			debounce = nil
+			next, err := r.inputSnapshot(subset, outputs)
+			if err != nil {
+				fmt.Fprintf(r.Stderr, "%s %v\n", r.paint("31", "[watch]"), err)
+				continue
+			}
+			changed := changedTasks(snap, next)
+			snap = next
+			rewatch()
+			if len(changed) == 0 {
+				continue
+			}
+
+			fmt.Fprintf(r.Stdout, "%s inputs of %s changed\n", r.paint("35", "[watch]"), strings.Join(changed, ", "))
+			for _, name := range affectedTasks(changed, dependents) {
+				pending[name] = true
+			}
+			if runDone != nil {
+				runCancel()
+				collect(<-runDone)
+			}
+			start()
+
+		case err := <-runDone:
+			collect(err)
+			fmt.Fprintf(r.Stdout, "%s waiting for changes\n", r.paint("35", "[watch]"))
+		}
+	}
+}
+
+func (r *Runner) newWatcher() watcher {
+	if !r.WatchPoll {
+		w, err := newNotifyWatcher()
+		if err == nil {
+			return w
+		}
+	}
+	return newPollWatcher(r.watchInterval())
+}
+
+func (r *Runner) watchDebounce() time.Duration {
+	if r.WatchDebounce > 0 {
+		return r.WatchDebounce
+	}
+	return defaultWatchDebounce
+}
+
+func (r *Runner) watchInterval() time.Duration {
+	if r.WatchInterval > 0 {
+		return r.WatchInterval
+	}
+	return defaultWatchInterval
+}
+
+// watchDirs lists the directories that can contain files matched by the
+// inputs of the tasks in subset.
+func (r *Runner) watchDirs(subset map[string]bool) ([]string, error) {
+	ignore, err := r.ignoreRules()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	dirs := make([]string, 0, 16)
+	add := func(dir string) {
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	walk := func(root string) error {
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() {
+				return nil
+			}
+			if p != root && ignore.ignored(relPath(r.File.Dir, p)) {
+				return filepath.SkipDir
+			}
+			add(p)
+			return nil
+		})
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	for _, name := range r.File.Order {
+		if !subset[name] {
+			continue
+		}
+		t := r.File.Tasks[name]
+		for _, pattern := range r.File.ExpandTaskList(t, t.Inputs) {
+			if strings.HasPrefix(pattern, "!") {
+				continue
+			}
+			full := pattern
+			if !filepath.IsAbs(full) {
+				full = filepath.Join(r.File.TaskFile(t).Dir, pattern)
+			}
+			if hasGlob(full) {
+				root, _ := globRoot(full)
+				if err := walk(root); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			info, err := os.Stat(full)
+			switch {
+			case err == nil && info.IsDir():
+				if err := walk(full); err != nil {
+					return nil, err
+				}
+			case err == nil || os.IsNotExist(err):
+				if _, err := os.Stat(filepath.Dir(full)); err == nil {
+					add(filepath.Dir(full))
+				}
+			default:
+				return nil, err
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// outputRules matches the declared outputs of the tasks in subset, so that
+// files written by the run itself do not count as input changes.
+func (r *Runner) outputRules(subset map[string]bool) ignoreRules {
+	rules := make(ignoreRules, 0, len(subset))
+	for name := range subset {
+		t := r.File.Tasks[name]
+		for _, out := range r.File.ExpandTaskList(t, t.Outputs) {
+			if strings.HasPrefix(out, "!") || filepath.IsAbs(out) {
+				continue
+			}
+			// Rules are rooted at the top-level Remfile; outputs of an
+			// included one are relative to its own directory.
+			if base := r.File.TaskFile(t).Dir; base != r.File.Dir {
+				dir, _ := filepath.Rel(r.File.Dir, base)
+				out = filepath.ToSlash(dir) + "/" + out
+			}
+			if rule, ok := parsePathRule("/" + out); ok {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules
+}
+
+func (r *Runner) inputSnapshot(subset map[string]bool, outputs ignoreRules) (inputSnapshot, error) {
+	snap := make(inputSnapshot, len(subset))
+	for name := range subset {
+		t := r.File.Tasks[name]
+		files, _, err := r.resolvePatterns(r.File.TaskFile(t).Dir, r.File.ExpandTaskList(t, t.Inputs))
+		if err != nil {
+			return nil, err
+		}
+		stamps := make(map[string]fileStamp, len(files))
+		for _, f := range files {
+			if outputs.ignored(relPath(r.File.Dir, f.Path)) {
+				continue
+			}
+			info, err := os.Stat(f.Path)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+			stamps[f.Path] = fileStamp{size: info.Size(), mod: info.ModTime()}
+		}
+		snap[name] = stamps
+	}
+	return snap, nil
+}
+
+func changedTasks(old, cur inputSnapshot) []string {
+	changed := make([]string, 0, len(cur))
+	for name, stamps := range cur {
+		prev := old[name]
+		same := len(prev) == len(stamps)
+		for p, st := range stamps {
+			if !same {
+				break
+			}
+			if o, ok := prev[p]; !ok || o.size != st.size || !o.mod.Equal(st.mod) {
+				same = false
+			}
+		}
+		if !same {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// affectedTasks returns changed plus every task that transitively depends
+// on one of them.
+func affectedTasks(changed []string, dependents map[string][]string) []string {
+	seen := make(map[string]bool, len(changed))
+	queue := append([]string(nil), changed...)
+	out := make([]string, 0, len(changed))
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, name)
+		queue = append(queue, dependents[name]...)
+	}
+	return out
+}
+
+type service struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// serviceSet tracks the long-running tasks started by Watch. They outlive
+// the run that started them and are stopped before being started again.
+type serviceSet struct {
+	ctx   context.Context
+	mu    sync.Mutex
+	procs map[string]*service
+}
+
+func (s *serviceSet) start(name string, run func(context.Context) error, exited func(error)) {
+	s.stop(name)
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	svc := &service{cancel: cancel, done: make(chan struct{})}
+	s.mu.Lock()
+	s.procs[name] = svc
+	s.mu.Unlock()
+
+	go func() {
+		defer close(svc.done)
+		err := run(ctx)
+		if ctx.Err() == nil {
+			exited(err)
+		}
+	}()
+}
+
+func (s *serviceSet) stop(name string) {
+	s.mu.Lock()
+	svc := s.procs[name]
+	delete(s.procs, name)
+	s.mu.Unlock()
+	if svc != nil {
+		svc.cancel()
+		<-svc.done
+	}
+}
+
+func (s *serviceSet) stopAll() {
+	s.mu.Lock()
+	names := make([]string, 0, len(s.procs))
+	for name := range s.procs {
+		names = append(names, name)
+	}
+	s.mu.Unlock()
+	for _, name := range names {
+		s.stop(name)
+	}
+}
+
+func (r *Runner) startService(task *remfile.Task) {
+	r.services.start(task.Name, func(ctx context.Context) error {
+		out := r.openStreamOutput(task.Name)
+		defer out.flush()
+		return r.runCommands(ctx, task, out, 1)
+	}, func(err error) {
+		if err != nil {
+			fmt.Fprintf(r.Stderr, "%s %s: %v\n", r.paint("31", "[exit]"), task.Name, err)
+			return
+		}
+		fmt.Fprintf(r.Stdout, "%s %s\n", r.paint("33", "[exit]"), task.Name)
+	})
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package engine
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_ATTRIB | syscall.IN_DELETE_SELF
+
+type inotifyWatcher struct {
+	fd int
+	f  *os.File
+	ch chan struct{}
+}
+
+func newNotifyWatcher() (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// A non-blocking descriptor is handed to the runtime poller, so Close
+	// unblocks the reader goroutine.
+	w := &inotifyWatcher{fd: fd, f: os.NewFile(uintptr(fd), "inotify"), ch: make(chan struct{}, 1)}
+	go w.read()
+	return w, nil
+}
+
+// watch adds every directory again: re-adding an existing watch is a no-op
+// and directories that were removed and recreated get a fresh one.
+func (w *inotifyWatcher) watch(dirs []string) error {
+	for _, dir := range dirs {
+		if _, err := syscall.InotifyAddWatch(w.fd, dir, inotifyMask); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("watch %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func (w *inotifyWatcher) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		if _, err := w.f.Read(buf); err != nil {
+			return
+		}
+		select {
+		case w.ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *inotifyWatcher) changes() <-chan struct{} { return w.ch }
+
+func (w *inotifyWatcher) close() error {
+	return w.f.Close()
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package engine
+
+import "errors"
+
+func newNotifyWatcher() (watcher, error) {
+	return nil, errors.New("file notifications are not supported on this platform")
+}
@@ -0,0 +1,143 @@
+package engine
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"rem/internal/remfile"
+)
+
+func startWatch(t *testing.T, r *Runner, target string) func() {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Watch(ctx, target) }()
+	return func() {
+		cancel()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Watch() error: %v", err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("Watch() did not stop")
+		}
+	}
+}
+
+func waitForLog(t *testing.T, path string, want string) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		raw, _ := os.ReadFile(path)
+		if string(raw) == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	raw, _ := os.ReadFile(path)
+	t.Fatalf("log = %q, want %q", raw, want)
+}
+
+func writeFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchRerunsAffectedTasks(t *testing.T) {
+	for _, poll := range []bool{false, true} {
+		name := "notify"
+		if poll {
+			name = "poll"
+		}
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFile(t, filepath.Join(dir, "a.txt"), "a")
+			writeFile(t, filepath.Join(dir, "b.txt"), "b")
+			logPath := filepath.Join(dir, "log")
+
+			rf := &remfile.File{
+				Dir:     dir,
+				Default: "all",
+				Order:   []string{"a", "b", "all"},
+				Tasks: map[string]*remfile.Task{
+					"a":   {Name: "a", Inputs: []string{"a.txt"}, Cmds: []string{"echo a >> log"}},
+					"b":   {Name: "b", Inputs: []string{"b.txt"}, Cmds: []string{"echo b >> log"}},
+					"all": {Name: "all", Deps: []string{"a", "b"}, Cmds: []string{"echo all >> log"}},
+				},
+			}
+			r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard, WatchPoll: poll, WatchInterval: 20 * time.Millisecond, WatchDebounce: 20 * time.Millisecond}
+			stop := startWatch(t, r, "all")
+			defer stop()
+
+			waitForLog(t, logPath, "a\nb\nall\n")
+			writeFile(t, filepath.Join(dir, "b.txt"), "b changed")
+			waitForLog(t, logPath, "a\nb\nall\nb\nall\n")
+		})
+	}
+}
+
+func TestWatchCancelsInFlightRun(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "src.txt"), "1")
+	logPath := filepath.Join(dir, "log")
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "slow",
+		Order:   []string{"slow"},
+		Tasks: map[string]*remfile.Task{
+			"slow": {Name: "slow", Inputs: []string{"src.txt"}, Cmds: []string{"echo begin >> log", "sleep 30"}},
+		},
+	}
+	r := &Runner{File: rf, Jobs: 1, Stdout: io.Discard, Stderr: io.Discard, WatchDebounce: 20 * time.Millisecond, GracePeriod: time.Second}
+	stop := startWatch(t, r, "slow")
+
+	waitForLog(t, logPath, "begin\n")
+	writeFile(t, filepath.Join(dir, "src.txt"), "22")
+	waitForLog(t, logPath, "begin\nbegin\n")
+
+	start := time.Now()
+	stop()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("stopping watch took %s", elapsed)
+	}
+}
+
+func TestWatchRestartsServiceOnlyWhenAffected(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "server.txt"), "1")
+	writeFile(t, filepath.Join(dir, "docs.txt"), "1")
+	serveLog := filepath.Join(dir, "serve.log")
+	docsLog := filepath.Join(dir, "docs.log")
+
+	rf := &remfile.File{
+		Dir:     dir,
+		Default: "dev",
+		Order:   []string{"serve", "docs", "dev"},
+		Tasks: map[string]*remfile.Task{
+			"serve": {Name: "serve", Inputs: []string{"server.txt"}, Restart: true, Cmds: []string{"echo start >> serve.log; sleep 30"}},
+			"docs":  {Name: "docs", Inputs: []string{"docs.txt"}, Cmds: []string{"echo build >> docs.log"}},
+			"dev":   {Name: "dev", Deps: []string{"serve", "docs"}},
+		},
+	}
+	r := &Runner{File: rf, Jobs: 2, Stdout: io.Discard, Stderr: io.Discard, WatchDebounce: 20 * time.Millisecond, GracePeriod: time.Second}
+	stop := startWatch(t, r, "dev")
+	defer stop()
+
+	waitForLog(t, serveLog, "start\n")
+	waitForLog(t, docsLog, "build\n")
+
+	writeFile(t, filepath.Join(dir, "docs.txt"), "22")
+	waitForLog(t, docsLog, "build\nbuild\n")
+	waitForLog(t, serveLog, "start\n")
+
+	writeFile(t, filepath.Join(dir, "server.txt"), "22")
+	waitForLog(t, serveLog, "start\nstart\n")
+}
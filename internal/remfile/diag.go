@@ -0,0 +1,289 @@
+package remfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"rem/internal/toml"
+)
+
+// Span is a range of a Remfile. Lines and columns are 1-based, columns
+// count runes, and the end is exclusive.
+type Span struct {
+	File    string `json:"file"`
+	Line    int    `json:"line,omitempty"`
+	Col     int    `json:"col,omitempty"`
+	EndLine int    `json:"end_line,omitempty"`
+	EndCol  int    `json:"end_col,omitempty"`
+}
+
+func (s Span) IsValid() bool {
+	return s.Line > 0
+}
+
+// before orders spans by position; spans without one sort last.
+func (s Span) before(t Span) bool {
+	if !s.IsValid() || !t.IsValid() {
+		return s.IsValid() && !t.IsValid()
+	}
+	return s.Line < t.Line || (s.Line == t.Line && s.Col < t.Col)
+}
+
+func (s Span) String() string {
+	if !s.IsValid() {
+		return s.File
+	}
+	return fmt.Sprintf("%s:%d:%d", s.File, s.Line, s.Col)
+}
+
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Error is a problem at a place in a Remfile. It renders like a compiler
+// diagnostic, with the offending line and a caret under the problem:
+//
+//	Remfile:12:16: task "build" depends on undefined task "tset"
+//	   |
+//	12 | deps = ["gen", "tset"]
+//	   |                ^^^^^^
+//	   = did you mean "test"?
+//
+// Warnings use the same type and render with a "warning: " prefix.
+type Error struct {
+	Severity Severity `json:"severity"`
+	Span     Span     `json:"span"`
+	Msg      string   `json:"message"`
+	Hint     string   `json:"hint,omitempty"`
+
+	line string
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	if e.Span.File != "" || e.Span.IsValid() {
+		b.WriteString(e.Span.String())
+		b.WriteString(": ")
+	}
+	if e.Severity == SeverityWarning {
+		b.WriteString("warning: ")
+	}
+	b.WriteString(e.Msg)
+	if !e.Span.IsValid() || e.line == "" {
+		if e.Hint != "" {
+			b.WriteString("; " + e.Hint)
+		}
+		return b.String()
+	}
+
+	num := strconv.Itoa(e.Span.Line)
+	gutter := strings.Repeat(" ", len(num))
+	fmt.Fprintf(&b, "\n%s |\n%s | %s\n%s | ", gutter, num, e.line, gutter)
+	col := 1
+	for _, r := range e.line {
+		if col >= e.Span.Col {
+			break
+		}
+		if r == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+		col++
+	}
+	width := utf8.RuneCountInString(e.line) - e.Span.Col + 1
+	if e.Span.EndLine == e.Span.Line {
+		width = e.Span.EndCol - e.Span.Col
+	}
+	b.WriteString(strings.Repeat("^", max(width, 1)))
+	if e.Hint != "" {
+		fmt.Fprintf(&b, "\n%s = %s", gutter, e.Hint)
+	}
+	return b.String()
+}
+
+func (f *File) span(s toml.Span) Span {
+	return Span{File: f.name, Line: s.Start.Line, Col: s.Start.Col, EndLine: s.End.Line, EndCol: s.End.Col}
+}
+
+func (f *File) errorf(s Span, format string, args ...any) *Error {
+	e := &Error{Severity: SeverityError, Span: s, Msg: fmt.Sprintf(format, args...)}
+	if s.IsValid() && s.Line <= len(f.lines) {
+		e.line = f.lines[s.Line-1]
+	}
+	return e
+}
+
+func (f *File) warnf(s Span, format string, args ...any) {
+	e := f.errorf(s, format, args...)
+	e.Severity = SeverityWarning
+	f.report(e)
+}
+
+func (f *File) report(e *Error) {
+	f.diags = append(f.diags, e)
+	if e.Severity == SeverityWarning {
+		f.Warnings = append(f.Warnings, e)
+	}
+}
+
+// Diagnostics are the errors and warnings found in a Remfile, in source
+// order.
+type Diagnostics []*Error
+
+func (d Diagnostics) Error() string {
+	parts := make([]string, 0, len(d))
+	for _, e := range d {
+		parts = append(parts, e.Error())
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func (d Diagnostics) HasErrors() bool {
+	return d.Count(SeverityError) > 0
+}
+
+func (d Diagnostics) Count(sev Severity) int {
+	n := 0
+	for _, e := range d {
+		if e.Severity == sev {
+			n++
+		}
+	}
+	return n
+}
+
+// Err returns the errors in d as an error: nil when there are none, the
+// *Error itself when there is one, and Diagnostics otherwise.
+func (d Diagnostics) Err() error {
+	var errs Diagnostics
+	for _, e := range d {
+		if e.Severity == SeverityError {
+			errs = append(errs, e)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return errs
+}
+
+// WriteJSON writes d for editor integrations, as printed by
+// `rem check --json`:
+//
+//	{"diagnostics": [{"severity": "error", "span": {...}, "message": "..."}], "errors": 1, "warnings": 0}
+func (d Diagnostics) WriteJSON(w io.Writer) error {
+	out := struct {
+		Diagnostics Diagnostics `json:"diagnostics"`
+		Errors      int         `json:"errors"`
+		Warnings    int         `json:"warnings"`
+	}{d, d.Count(SeverityError), d.Count(SeverityWarning)}
+	if out.Diagnostics == nil {
+		out.Diagnostics = Diagnostics{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// narrow shrinks s to the only occurrence of text on its line, such as a
+// ${VAR} inside a longer string.
+func (f *File) narrow(s Span, text string) Span {
+	if !s.IsValid() || s.Line != s.EndLine || s.Line > len(f.lines) {
+		return s
+	}
+	line := []rune(f.lines[s.Line-1])
+	end := min(s.EndCol-1, len(line))
+	if s.Col-1 > end {
+		return s
+	}
+	inSpan := string(line[s.Col-1 : end])
+	i := strings.Index(inSpan, text)
+	if i < 0 || strings.Count(inSpan, text) != 1 {
+		return s
+	}
+	s.Col += utf8.RuneCountInString(inSpan[:i])
+	s.EndCol = s.Col + utf8.RuneCountInString(text)
+	return s
+}
+
+// SuggestTask returns the defined task closest to a misspelled name, or ""
+// when none is close.
+func (f *File) SuggestTask(name string) string {
+	names := make([]string, 0, len(f.Tasks))
+	for n := range f.Tasks {
+		names = append(names, n)
+	}
+	return Suggest(name, names)
+}
+
+// NoSuchTask is the error for a task named on the command line that the
+// Remfile does not define, with the closest defined task as a suggestion.
+func (f *File) NoSuchTask(what, name string) error {
+	if s := f.SuggestTask(name); s != "" {
+		return fmt.Errorf("%s %q does not exist; did you mean %q?", what, name, s)
+	}
+	return fmt.Errorf("%s %q does not exist", what, name)
+}
+
+// Suggest returns the candidate most likely meant by a misspelled name, or
+// "" when none is within a third of its length in edits.
+func Suggest(name string, candidates []string) string {
+	sorted := append([]string(nil), candidates...)
+	sort.Strings(sorted)
+	best, bestDist := "", max(utf8.RuneCountInString(name)/3, 1)+1
+	for _, c := range sorted {
+		if c == name {
+			continue
+		}
+		if d := editDistance(strings.ToLower(name), strings.ToLower(c)); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+func didYouMean(name string, candidates []string) string {
+	if s := Suggest(name, candidates); s != "" {
+		return fmt.Sprintf("did you mean %q?", s)
+	}
+	return ""
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent runes that turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
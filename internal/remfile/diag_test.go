@@ -0,0 +1,186 @@
+package remfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseErrorShowsSourceAndSuggestion(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			"undefined dep",
+			"[task.gen]\ncmd = \"go generate\"\n[task.test]\ncmd = \"go test\"\n[task.build]\ndeps = [\"gen\", \"tset\"]\n",
+			"Remfile:6:16: task \"build\" depends on undefined task \"tset\"\n" +
+				"  |\n" +
+				"6 | deps = [\"gen\", \"tset\"]\n" +
+				"  |                ^^^^^^\n" +
+				"  = did you mean \"test\"?",
+		},
+		{
+			"unresolved var",
+			"[vars]\nVERSION = \"1\"\nTAG = \"v${VERSOIN}\"\n\n[task.a]\ncmd = \"x\"\n",
+			"Remfile:3:9: var \"TAG\": unable to resolve \"${VERSOIN}\"\n" +
+				"  |\n" +
+				"3 | TAG = \"v${VERSOIN}\"\n" +
+				"  |         ^^^^^^^^^^\n" +
+				"  = did you mean \"VERSION\"?",
+		},
+		{
+			"unknown field",
+			"[task.a]\n\tcmnds = [\"x\"]\n",
+			"Remfile:2:2: unknown task field \"cmnds\"\n" +
+				"  |\n" +
+				"2 | \tcmnds = [\"x\"]\n" +
+				"  | \t^^^^^\n" +
+				"  = did you mean \"cmds\"?",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(bytes.NewBufferString(tt.content))
+			if err == nil {
+				t.Fatal("Parse() succeeded")
+			}
+			if err.Error() != tt.want {
+				t.Fatalf("error =\n%s\nwant\n%s", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeepsSpans(t *testing.T) {
+	rf, err := Parse(bytes.NewBufferString("default = \"b\"\n[vars]\nA = \"1\"\n[task.a]\ncmd = \"x\"\n[task.b]\ndeps = \"a\"\ncmds = [\n  \"one\",\n  \"two\",\n]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := rf.Tasks["b"]
+	if b.Span != (Span{File: "Remfile", Line: 6, Col: 7, EndLine: 6, EndCol: 8}) {
+		t.Fatalf("task span = %+v", b.Span)
+	}
+	if len(b.DepSpans) != 1 || b.DepSpans[0].Line != 7 || b.DepSpans[0].Col != 8 {
+		t.Fatalf("dep spans = %+v", b.DepSpans)
+	}
+	if len(b.CmdSpans) != 2 || b.CmdSpans[1].Line != 10 || b.CmdSpans[1].Col != 3 {
+		t.Fatalf("cmd spans = %+v", b.CmdSpans)
+	}
+	if got := rf.Tasks["a"].CmdSpans; len(got) != 1 || got[0].Line != 5 {
+		t.Fatalf("cmd span = %+v", got)
+	}
+	if got := rf.VarSpans["A"]; got.Line != 3 || got.Col != 5 {
+		t.Fatalf("var span = %+v", got)
+	}
+	if rf.DefaultSpan.Line != 1 || rf.DefaultSpan.Col != 11 {
+		t.Fatalf("default span = %+v", rf.DefaultSpan)
+	}
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"build", "test", "release", "release-assets", "docs.site"}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"biuld", "build"},
+		{"tset", "test"},
+		{"relase", "release"},
+		{"docs.sit", "docs.site"},
+		{"Build", "build"},
+		{"deploy", ""},
+		{"x", ""},
+	}
+	for _, tt := range tests {
+		if got := Suggest(tt.name, candidates); got != tt.want {
+			t.Errorf("Suggest(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiagnoseReportsEveryProblem(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Remfile")
+	content := "default = \"biuld\"\n\n" +
+		"[vars]\nTAG = \"${VERSON}\"\n\n" +
+		"[task.build]\ndeps = [\"gen\", \"gen\"]\nretries = \"2\"\n\n" +
+		"[task.gen]\ncmd = \"go generate\"\nouptuts = [\"x\"]\n\n" +
+		"[task.idle]\ndesc = \"nothing\"\n\n" +
+		"[include]\napi = \"api/Remfile\"\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rf, diags, err := Diagnose(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rf != nil {
+		t.Fatal("Diagnose returned a File despite errors")
+	}
+	var got []string
+	for _, d := range diags {
+		got = append(got, fmt.Sprintf("%s %d:%d %s", d.Severity, d.Span.Line, d.Span.Col, d.Msg))
+	}
+	want := []string{
+		`error 1:11 default task "biuld" is not defined`,
+		`error 4:8 var "TAG": unable to resolve "${VERSON}"`,
+		`warning 7:16 task "build" lists dependency "gen" more than once`,
+		`error 8:11 task "build" retries: expected integer, got string`,
+		`error 12:1 unknown task field "ouptuts"`,
+		`warning 14:7 task "idle" has no cmds and no deps, so it does nothing`,
+		`error 17:2 unsupported section "include"`,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diagnostics =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if _, err := Load(path); err == nil || strings.Count(err.Error(), "\n\n") != 4 {
+		t.Fatalf("Load() error = %v, want all five errors", err)
+	}
+
+	var buf bytes.Buffer
+	if err := diags.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var out struct {
+		Diagnostics []struct {
+			Severity string `json:"severity"`
+			Span     Span   `json:"span"`
+			Message  string `json:"message"`
+			Hint     string `json:"hint"`
+		} `json:"diagnostics"`
+		Errors   int `json:"errors"`
+		Warnings int `json:"warnings"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &out); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, buf.String())
+	}
+	if out.Errors != 5 || out.Warnings != 2 || len(out.Diagnostics) != 7 {
+		t.Fatalf("JSON counts = %d errors, %d warnings, %d diagnostics", out.Errors, out.Warnings, len(out.Diagnostics))
+	}
+	first := out.Diagnostics[0]
+	if first.Span.File != path || first.Span.Line != 1 || first.Hint != `did you mean "build"?` {
+		t.Fatalf("first diagnostic = %+v", first)
+	}
+	if last := out.Diagnostics[len(out.Diagnostics)-1]; last.Hint != `did you mean "includes"?` {
+		t.Fatalf("last diagnostic = %+v", last)
+	}
+}
+
+func TestLoadKeepsWarnings(t *testing.T) {
+	rf, err := Parse(bytes.NewBufferString("[task.a]\ncmd = \"x\"\n[task.b]\ndeps = [\"a\", \"a\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rf.Warnings) != 1 || !strings.HasPrefix(rf.Warnings[0].Error(), "Remfile:4:14: warning: ") {
+		t.Fatalf("warnings = %v", rf.Warnings)
+	}
+}
@@ -0,0 +1,259 @@
+package remfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseDotenv reads a .env file: `KEY=value` lines with an optional
+// `export ` prefix and `#` comments. Single-quoted values are literal;
+// double-quoted values may span lines and understand \n, \t, \" and \\
+// escapes. ${VAR} in unquoted and double-quoted values expands to keys
+// defined earlier in the file or, failing that, to lookup.
+func ParseDotenv(data string, lookup func(string) (string, bool)) ([]EnvVar, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	var out []EnvVar
+	expand := func(v string) string {
+		res, _ := expandTemplate(v, false, func(expr string) (string, bool, error) {
+			name, fallback, hasFallback := parseVarExpr(expr)
+			if i := envIndex(out, name); i >= 0 {
+				return out[i].Value, true, nil
+			}
+			if lookup != nil {
+				if v, ok := lookup(name); ok {
+					return v, true, nil
+				}
+			}
+			if hasFallback {
+				return fallback, true, nil
+			}
+			return "", true, nil
+		})
+		return res
+	}
+
+	line := 1
+	for len(data) > 0 {
+		var raw string
+		raw, data, _ = strings.Cut(data, "\n")
+		start := line
+		line++
+
+		text := strings.TrimSpace(raw)
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		text = strings.TrimPrefix(text, "export ")
+		key, val, ok := strings.Cut(text, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !isVarName(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=value", start)
+		}
+		val = strings.TrimLeft(val, " \t")
+
+		var value string
+		switch {
+		case strings.HasPrefix(val, "'") || strings.HasPrefix(val, `"`):
+			quote := val[0]
+			body := val[1:]
+			end := closingQuote(body, quote)
+			for end < 0 && len(data) > 0 {
+				var next string
+				next, data, _ = strings.Cut(data, "\n")
+				line++
+				body += "\n" + next
+				end = closingQuote(body, quote)
+			}
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated quoted value for %s", start, key)
+			}
+			rest := strings.TrimSpace(body[end+1:])
+			if rest != "" && !strings.HasPrefix(rest, "#") {
+				return nil, fmt.Errorf("line %d: unexpected text after quoted value for %s", start, key)
+			}
+			value = body[:end]
+			if quote == '"' {
+				value = expand(unescapeDotenv(value))
+			}
+		default:
+			if i := strings.Index(val, " #"); i >= 0 {
+				val = val[:i]
+			}
+			value = expand(strings.TrimSpace(val))
+		}
+
+		if i := envIndex(out, key); i >= 0 {
+			out[i].Value = value
+			continue
+		}
+		out = append(out, EnvVar{Name: key, Value: value})
+	}
+	return out, nil
+}
+
+func closingQuote(s string, quote byte) int {
+	for i := 0; i < len(s); i++ {
+		if quote == '"' && s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+func unescapeDotenv(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case '"', '\\', '$':
+			b.WriteByte(s[i])
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// LoadDotenv reads the top-level and per-task dotenv files relative to the
+// Remfile directory and re-resolves vars with them. Missing files are
+// skipped. Load already does this; callers that Parse a Remfile themselves
+// call it after setting Dir.
+func (f *File) LoadDotenv() error {
+	if err := f.readDotenvFiles(f.RawVars); err != nil {
+		return err
+	}
+	resolved, err := resolveVars(f.RawVars, f.lookupEnv)
+	if err != nil {
+		return err
+	}
+	f.Vars = resolved
+	return nil
+}
+
+// readDotenvFiles reads the dotenv files with their paths expanded by raw,
+// the vars before they are resolved.
+func (f *File) readDotenvFiles(raw map[string]string) error {
+	// Vars may use dotenv values, so the vars in dotenv paths resolve
+	// against the process environment alone.
+	vars, _ := resolveVars(raw, os.LookupEnv)
+	var err error
+	if f.dotenv, err = f.readDotenv(f.Dotenv, f.dotenvSpans, raw, vars, nil); err != nil {
+		return err
+	}
+	for _, name := range f.Order {
+		t := f.Tasks[name]
+		if t.dotenv, err = f.readDotenv(t.Dotenv, t.dotenvSpans, raw, vars, f.dotenv); err != nil {
+			var e *Error
+			if errors.As(err, &e) {
+				return e
+			}
+			return fmt.Errorf("task %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (f *File) readDotenv(paths []string, spans []Span, raw, vars map[string]string, inherited []EnvVar) ([]EnvVar, error) {
+	var out []EnvVar
+	var pathErr error
+	for i, written := range paths {
+		var unresolved string
+		p := expandStringLoose(written, vars, func(name string) (string, bool) {
+			if _, ok := raw[name]; ok && unresolved == "" {
+				unresolved = name
+			}
+			return os.LookupEnv(name)
+		})
+		if unresolved != "" {
+			var span Span
+			if i < len(spans) {
+				span = f.narrow(spans[i], "${"+unresolved+"}")
+			}
+			if pathErr == nil {
+				pathErr = f.errorf(span, "dotenv path %q uses var %q, which cannot be resolved before dotenv files are read", written, unresolved)
+			}
+			continue
+		}
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(f.Dir, p)
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		values, err := ParseDotenv(string(data), func(name string) (string, bool) {
+			if i := envIndex(out, name); i >= 0 {
+				return out[i].Value, true
+			}
+			if v, ok := os.LookupEnv(name); ok {
+				return v, true
+			}
+			if i := envIndex(inherited, name); i >= 0 {
+				return inherited[i].Value, true
+			}
+			return "", false
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", p, err)
+		}
+		// Later files override earlier ones, as with .env then .env.local.
+		for _, v := range values {
+			if i := envIndex(out, v.Name); i >= 0 {
+				out[i] = v
+				continue
+			}
+			out = append(out, v)
+		}
+	}
+	return out, pathErr
+}
+
+// lookupEnv resolves names that are not vars: the process environment
+// first, then the top-level dotenv files.
+func (f *File) lookupEnv(name string) (string, bool) {
+	if v, ok := os.LookupEnv(name); ok {
+		return v, true
+	}
+	if i := envIndex(f.dotenv, name); i >= 0 {
+		return f.dotenv[i].Value, true
+	}
+	return "", false
+}
+
+// TaskDotenv returns the dotenv values visible to a task, its own files
+// overriding the top-level ones.
+func (f *File) TaskDotenv(t *Task) []EnvVar {
+	if !f.local(t) {
+		return t.file.TaskDotenv(t)
+	}
+	out := append([]EnvVar(nil), f.dotenv...)
+	for _, v := range t.dotenv {
+		if i := envIndex(out, v.Name); i >= 0 {
+			out[i] = v
+			continue
+		}
+		out = append(out, v)
+	}
+	return out
+}
@@ -0,0 +1,153 @@
+package remfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDotenvQuoting(t *testing.T) {
+	data := `# comment
+export HOST=localhost
+PORT = 5432 # inline comment
+URL="postgres://${HOST}:${PORT}/db"
+LITERAL='${HOST} stays'
+MULTI="line one
+line two\tend"
+ESCAPED="say \"hi\" \$HOME"
+EMPTY=
+FROM_ENV=${REM_DOTENV_TEST_HOME}
+`
+	vars, err := ParseDotenv(data, func(name string) (string, bool) {
+		if name == "REM_DOTENV_TEST_HOME" {
+			return "/home/rem", true
+		}
+		return "", false
+	})
+	if err != nil {
+		t.Fatalf("ParseDotenv() error: %v", err)
+	}
+	want := []EnvVar{
+		{"HOST", "localhost"},
+		{"PORT", "5432"},
+		{"URL", "postgres://localhost:5432/db"},
+		{"LITERAL", "${HOST} stays"},
+		{"MULTI", "line one\nline two\tend"},
+		{"ESCAPED", `say "hi" $HOME`},
+		{"EMPTY", ""},
+		{"FROM_ENV", "/home/rem"},
+	}
+	if len(vars) != len(want) {
+		t.Fatalf("vars = %q", vars)
+	}
+	for i := range want {
+		if vars[i] != want[i] {
+			t.Fatalf("vars[%d] = %q, want %q", i, vars[i], want[i])
+		}
+	}
+
+	if _, err := ParseDotenv("NOT VALID\n", nil); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("expected line error, got %v", err)
+	}
+	if _, err := ParseDotenv("A=\"open\n", nil); err == nil {
+		t.Fatalf("expected unterminated quote error")
+	}
+}
+
+func TestLoadDotenvPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".env", "DB_HOST=db\nDB_PORT=5432\nREM_DOTENV_OS=from-dotenv\nMODE=dotenv\n")
+	write(".env.local", "DB_PORT=6543\n")
+	write("api.env", "DB_HOST=api-db\n")
+	write("Remfile", `
+default = "serve"
+dotenv = [".env", ".env.local", ".env.missing"]
+
+[vars]
+DSN = "${DB_HOST}:${DB_PORT}"
+MODE = "vars"
+
+[task.serve]
+dotenv = ["api.env"]
+cmds = ["serve ${DB_HOST} ${DSN} ${MODE} ${REM_DOTENV_OS}"]
+`)
+	t.Setenv("REM_DOTENV_OS", "from-os")
+
+	rf, err := Load(filepath.Join(dir, "Remfile"))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	if got := rf.Vars["DSN"]; got != "db:6543" {
+		t.Fatalf("DSN = %q", got)
+	}
+	task := rf.Tasks["serve"]
+	if got := rf.ExpandTask(task, task.Cmds[0]); got != "serve api-db db:6543 vars from-os" {
+		t.Fatalf("expanded cmd = %q", got)
+	}
+
+	if err := rf.ApplyOverrides(map[string]string{"MODE": "cli"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rf.ExpandString("${MODE} ${DSN}"); got != "cli db:6543" {
+		t.Fatalf("after -D: %q", got)
+	}
+}
+
+func TestDotenvPathsUseVars(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(".env.dev", "DB_HOST=dev-db\n")
+	write(".env.prod", "DB_HOST=prod-db\n")
+	write("Remfile", `
+dotenv = [".env.${STAGE}"]
+
+[vars]
+STAGE = "dev"
+
+[task.serve]
+cmds = ["serve ${DB_HOST}"]
+`)
+
+	rf, err := Load(filepath.Join(dir, "Remfile"))
+	if err != nil {
+		t.Fatalf("Load() error: %v", err)
+	}
+	task := rf.Tasks["serve"]
+	if got := rf.ExpandTask(task, task.Cmds[0]); got != "serve dev-db" {
+		t.Fatalf("expanded cmd = %q", got)
+	}
+	if err := rf.ApplyOverrides(map[string]string{"STAGE": "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rf.ExpandTask(task, task.Cmds[0]); got != "serve prod-db" {
+		t.Fatalf("after -D STAGE=prod: %q", got)
+	}
+
+	write("Remfile", `
+dotenv = [".env", ".env.${STAGE}"]
+
+[vars]
+STAGE = "${APP_STAGE}"
+
+[task.serve]
+cmds = ["serve ${DB_HOST}"]
+`)
+	write(".env", "APP_STAGE=prod\n")
+	_, err = Load(filepath.Join(dir, "Remfile"))
+	if err == nil || !strings.Contains(err.Error(), `Remfile:2:25: dotenv path ".env.${STAGE}" uses var "STAGE"`) {
+		t.Fatalf("expected an error for a dotenv path var that needs dotenv values, got %v", err)
+	}
+	if _, ok := err.(*Error); !ok {
+		t.Fatalf("expected only the dotenv path error, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package remfile
+
+import (
+	"strings"
+
+	"rem/internal/toml"
+)
+
+func (f *File) parseEnvTable(tbl *toml.Table) []EnvVar {
+	env := make([]EnvVar, 0, tbl.Len())
+	for _, key := range tbl.Keys() {
+		v, _ := tbl.Get(key)
+		if !isVarName(key) {
+			f.report(f.errorf(f.span(tbl.KeySpan(key)), "invalid env name %q", key))
+			continue
+		}
+		val, err := scalarValue(v)
+		if err != nil {
+			f.report(f.fieldError(tbl, key, err, "env %q", key))
+			continue
+		}
+		env = append(env, EnvVar{Name: key, Value: val})
+	}
+	return env
+}
+
+func formatEnvTable(env []EnvVar) string {
+	parts := make([]string, 0, len(env))
+	for _, e := range env {
+		parts = append(parts, formatKey(e.Name)+" = "+quoteTOML(e.Value))
+	}
+	return "{ " + strings.Join(parts, ", ") + " }"
+}
+
+func envIndex(env []EnvVar, name string) int {
+	for i, e := range env {
+		if e.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// TaskEnv returns the expanded variables a task adds to its command
+// environment: the top-level `[env]` table first, then the task's own
+// `env`, which wins on conflicts.
+func (f *File) TaskEnv(t *Task) []EnvVar {
+	if !f.local(t) {
+		return t.file.TaskEnv(t)
+	}
+	out := make([]EnvVar, 0, len(f.Env)+len(t.Env))
+	for _, layer := range [][]EnvVar{f.Env, t.Env} {
+		for _, e := range layer {
+			v := EnvVar{Name: e.Name, Value: f.ExpandTask(t, e.Value)}
+			if i := envIndex(out, e.Name); i >= 0 {
+				out[i] = v
+				continue
+			}
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,154 @@
+package remfile
+
+import (
+	"fmt"
+	"strings"
+
+	"rem/internal/toml"
+)
+
+// FormatWidth is the line width past which `rem format` puts one array
+// item, typically one command, per line.
+const FormatWidth = 80
+
+// FormatSource reformats Remfile source the way `rem format` does. Unlike
+// Format it works on the text, so comments, blank lines and key order are
+// kept and only whitespace, quoting, indentation and array wrapping change.
+func FormatSource(src []byte) ([]byte, error) {
+	doc, err := toml.ParseDocument(src)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Format(FormatWidth), nil
+}
+
+// Diff returns a unified diff turning before into after, as printed by
+// `rem format --diff`, or "" when they are equal.
+func Diff(name string, before, after []byte) string {
+	a := splitLines(string(before))
+	b := splitLines(string(after))
+	ops := diffLines(a, b)
+
+	const context = 3
+	var out strings.Builder
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+		// Grow the hunk while changes are at most 2*context lines apart.
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end = min(end+context, len(ops))
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s (formatted)\n", name, name)
+		}
+		aStart, bStart := ops[start].a, ops[start].b
+		var aLen, bLen int
+		var body strings.Builder
+		for _, op := range ops[start:end] {
+			switch op.kind {
+			case ' ':
+				aLen++
+				bLen++
+			case '-':
+				aLen++
+			case '+':
+				bLen++
+			}
+			body.WriteByte(op.kind)
+			body.WriteString(op.line)
+			body.WriteByte('\n')
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(aStart, aLen), hunkRange(bStart, bLen))
+		out.WriteString(body.String())
+		i = end
+	}
+	return out.String()
+}
+
+type diffOp struct {
+	kind byte
+	line string
+	a    int
+	b    int
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
+
+// diffLines is a longest-common-subsequence line diff. Remfiles are small,
+// and the common prefix and suffix are skipped before the quadratic part.
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for k := 0; k < prefix; k++ {
+		ops = append(ops, diffOp{kind: ' ', line: a[k], a: k, b: k})
+	}
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			ops = append(ops, diffOp{kind: ' ', line: ma[i], a: prefix + i, b: prefix + j})
+			i++
+			j++
+		case i < len(ma) && (j == len(mb) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: ma[i], a: prefix + i, b: prefix + j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: mb[j], a: prefix + i, b: prefix + j})
+			j++
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		ai, bi := len(a)-suffix+k, len(b)-suffix+k
+		ops = append(ops, diffOp{kind: ' ', line: a[ai], a: ai, b: bi})
+	}
+	return ops
+}
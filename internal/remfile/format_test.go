@@ -0,0 +1,53 @@
+package remfile
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatSourceKeepsComments(t *testing.T) {
+	src := `# Remfile for the api
+default = "build"
+
+[task.build]
+# compile everything
+cmds = ["mkdir -p bin", "go build -o bin/api -ldflags \"-X main.version=${VERSION}\" ./cmd/api"]
+`
+	want := `# Remfile for the api
+default = "build"
+
+[task.build]
+# compile everything
+cmds = [
+  "mkdir -p bin",
+  "go build -o bin/api -ldflags \"-X main.version=${VERSION}\" ./cmd/api",
+]
+`
+	got, err := FormatSource([]byte(src))
+	if err != nil {
+		t.Fatalf("FormatSource() error: %v", err)
+	}
+	if string(got) != want {
+		t.Fatalf("FormatSource() =\n%s\nwant\n%s", got, want)
+	}
+
+	diff := Diff("Remfile", []byte(src), got)
+	for _, line := range []string{
+		"--- Remfile\n+++ Remfile (formatted)\n",
+		"@@ -3,4 +3,7 @@\n \n [task.build]\n # compile everything\n-cmds = [",
+		"\n+cmds = [\n+  \"mkdir -p bin\",\n",
+	} {
+		if !strings.Contains(diff, line) {
+			t.Fatalf("Diff() missing %q:\n%s", line, diff)
+		}
+	}
+	if d := Diff("Remfile", got, got); d != "" {
+		t.Fatalf("Diff() of equal input = %q", d)
+	}
+}
+
+func TestFormatSourceRejectsInvalidTOML(t *testing.T) {
+	if _, err := FormatSource([]byte("[task.build\ncmds = []")); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("FormatSource() error = %v, want line 1", err)
+	}
+}
@@ -0,0 +1,217 @@
+package remfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"rem/internal/toml"
+)
+
+// include is one entry of the `includes` table: another Remfile whose tasks
+// are merged in as "<name>:<task>". Vars holds overrides for the included
+// file's vars, written in the scope of the including one.
+type include struct {
+	name string
+	path string
+	vars map[string]string
+	span Span
+	file *File
+}
+
+// loadScope is what a Remfile knows about the files that include it.
+type loadScope struct {
+	// chain holds the absolute paths of the Remfiles being loaded,
+	// outermost first, and names how they are shown in errors.
+	chain    []string
+	names    []string
+	included bool
+	vars     map[string]string
+}
+
+var includeFields = []string{"path", "vars"}
+
+// parseIncludes accepts `{ api = "services/api/Remfile" }` and the long form
+// `{ api = { path = "services/api/Remfile", vars = { VERSION = "${VERSION}" } } }`.
+func (f *File) parseIncludes(tbl *toml.Table) {
+	for _, name := range tbl.Keys() {
+		v, _ := tbl.Get(name)
+		if !isTaskName(name) {
+			f.report(f.errorf(f.span(tbl.KeySpan(name)), "invalid include name %q", name))
+			continue
+		}
+		inc := &include{name: name, span: f.span(tbl.ValueSpan(name))}
+		switch v := v.(type) {
+		case string:
+			inc.path = v
+		case *toml.Table:
+			for _, key := range v.Keys() {
+				fv, _ := v.Get(key)
+				switch key {
+				case "path":
+					s, err := stringValue(fv)
+					if err != nil {
+						f.report(f.fieldError(v, key, err, "include %q path", name))
+						continue
+					}
+					inc.path = s
+				case "vars":
+					vars, err := tableValue(fv)
+					if err != nil {
+						f.report(f.fieldError(v, key, err, "include %q vars", name))
+						continue
+					}
+					inc.vars = make(map[string]string, vars.Len())
+					for _, k := range vars.Keys() {
+						val, _ := vars.Get(k)
+						if !isVarName(k) {
+							f.report(f.errorf(f.span(vars.KeySpan(k)), "invalid variable name %q", k))
+							continue
+						}
+						s, err := scalarValue(val)
+						if err != nil {
+							f.report(f.fieldError(vars, k, err, "include %q var %q", name, k))
+							continue
+						}
+						inc.vars[k] = s
+					}
+				default:
+					e := f.errorf(f.span(v.KeySpan(key)), "include %q: unknown field %q", name, key)
+					e.Hint = didYouMean(key, includeFields)
+					f.report(e)
+				}
+			}
+			if inc.path == "" {
+				f.report(f.errorf(inc.span, "include %q: missing path", name))
+				continue
+			}
+		default:
+			f.report(f.errorf(inc.span, "include %q: expected string or table, got %s", name, toml.TypeName(v)))
+			continue
+		}
+		f.includes = append(f.includes, inc)
+	}
+}
+
+// loadIncludes parses the included Remfiles, relative to f's directory, and
+// merges their tasks into f. It runs once f's vars are resolved, since
+// include paths and var overrides may use them.
+func (f *File) loadIncludes(sc loadScope) {
+	for _, inc := range f.includes {
+		written := f.ExpandString(inc.path)
+		path, name := written, written
+		if !filepath.IsAbs(written) {
+			path = filepath.Join(f.Dir, written)
+			name = filepath.Join(filepath.Dir(f.name), written)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			f.report(f.errorf(inc.span, "include %q: %v", inc.name, err))
+			continue
+		}
+		if i := slices.Index(sc.chain, abs); i >= 0 {
+			cycle := append(append([]string(nil), sc.names[i:]...), name)
+			f.report(f.errorf(inc.span, "include cycle: %s", strings.Join(cycle, " -> ")))
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			f.report(f.errorf(inc.span, "include %q: %v", inc.name, err))
+			continue
+		}
+
+		child, diags := parseTOML(normalizeNewlines(content), filepath.Dir(abs), name, loadScope{
+			chain:    append(sc.chain[:len(sc.chain):len(sc.chain)], abs),
+			names:    append(sc.names[:len(sc.names):len(sc.names)], name),
+			included: true,
+			vars:     f.expandIncludeVars(inc),
+		})
+		child.Path = abs
+		inc.file = child
+		f.includeDiags = append(f.includeDiags, diags...)
+		f.Warnings = append(f.Warnings, child.Warnings...)
+		f.mergeTasks(inc.name, child)
+	}
+}
+
+func (f *File) expandIncludeVars(inc *include) map[string]string {
+	vars := make(map[string]string, len(inc.vars))
+	for k, v := range inc.vars {
+		vars[k] = f.ExpandString(v)
+	}
+	return vars
+}
+
+// applyVarOverrides sets vars before they are resolved, adding the ones the
+// file does not declare, in name order.
+func (f *File) applyVarOverrides(rawVars map[string]string, overrides map[string]string) {
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, exists := rawVars[name]; !exists {
+			f.VarOrder = append(f.VarOrder, name)
+		}
+		rawVars[name] = overrides[name]
+		f.RawVars[name] = overrides[name]
+	}
+}
+
+// mergeTasks adds the tasks of an included Remfile as "<prefix>:<name>".
+// Their deps are renamed the same way, except deps starting with ':', which
+// name a task of the outermost Remfile, such as ":web:build".
+func (f *File) mergeTasks(prefix string, child *File) {
+	for _, name := range child.Order {
+		t := child.Tasks[name]
+		t.Name = prefix + ":" + name
+		for i, dep := range t.Deps {
+			if !strings.HasPrefix(dep, ":") {
+				t.Deps[i] = prefix + ":" + dep
+			}
+		}
+		f.Tasks[t.Name] = t
+		f.Order = append(f.Order, t.Name)
+	}
+}
+
+// TaskFile returns the Remfile that defines t: f itself, or the included
+// Remfile t was merged from. t's commands run in its Dir and its relative
+// paths resolve there.
+func (f *File) TaskFile(t *Task) *File {
+	if t != nil && t.file != nil {
+		return t.file
+	}
+	return f
+}
+
+// local reports whether t is one of f's own tasks rather than an included
+// one.
+func (f *File) local(t *Task) bool {
+	return t.file == nil || t.file == f
+}
+
+func formatIncludes(incs []*include) string {
+	parts := make([]string, 0, len(incs))
+	for _, inc := range incs {
+		if len(inc.vars) == 0 {
+			parts = append(parts, formatKey(inc.name)+" = "+quoteTOML(inc.path))
+			continue
+		}
+		names := make([]string, 0, len(inc.vars))
+		for name := range inc.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		vars := make([]string, 0, len(names))
+		for _, name := range names {
+			vars = append(vars, formatKey(name)+" = "+quoteTOML(inc.vars[name]))
+		}
+		parts = append(parts, fmt.Sprintf("%s = { path = %s, vars = { %s } }", formatKey(inc.name), quoteTOML(inc.path), strings.Join(vars, ", ")))
+	}
+	return "{ " + strings.Join(parts, ", ") + " }"
+}
@@ -0,0 +1,137 @@
+package remfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLoadIncludesNamespacesTasks(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Remfile": `default = "all"
+includes = { api = "services/api/Remfile", web = { path = "services/web/Remfile", vars = { VERSION = "${VERSION}-web" } } }
+
+[vars]
+VERSION = "1.0"
+
+[task.all]
+deps = ["api:build", "web:build"]
+`,
+		"services/api/Remfile": `[vars]
+BIN = "api"
+
+[task.build]
+deps = ["gen", ":web:build"]
+outputs = ["bin/${BIN}"]
+cmd = "go build -o bin/${BIN}"
+
+[task.gen]
+cmd = "go generate"
+`,
+		"services/web/Remfile": `[vars]
+VERSION = "dev"
+
+[task.build]
+cmd = "npm run build -- ${VERSION}"
+`,
+	})
+
+	rf, err := Load(filepath.Join(dir, "Remfile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"all", "api:build", "api:gen", "web:build"}; !reflect.DeepEqual(rf.Order, want) {
+		t.Fatalf("order = %v, want %v", rf.Order, want)
+	}
+	build := rf.Tasks["api:build"]
+	if want := []string{"api:gen", "web:build"}; !reflect.DeepEqual(build.Deps, want) {
+		t.Fatalf("api:build deps = %v, want %v", build.Deps, want)
+	}
+	if got := rf.TaskFile(build).Dir; got != filepath.Join(dir, "services", "api") {
+		t.Fatalf("api:build dir = %q", got)
+	}
+	if got := rf.ExpandTaskList(build, build.Outputs); !reflect.DeepEqual(got, []string{"bin/api"}) {
+		t.Fatalf("api:build outputs = %v", got)
+	}
+	web := rf.Tasks["web:build"]
+	if got := rf.ExpandTask(web, web.Cmds[0]); got != "npm run build -- 1.0-web" {
+		t.Fatalf("web:build cmd = %q", got)
+	}
+	if _, ok := rf.Vars["BIN"]; ok {
+		t.Fatal("included vars leaked into the including Remfile")
+	}
+
+	if err := rf.ApplyOverrides(map[string]string{"VERSION": "2.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rf.ExpandTask(web, web.Cmds[0]); got != "npm run build -- 2.0-web" {
+		t.Fatalf("web:build cmd after override = %q", got)
+	}
+
+	out := Format(rf)
+	if strings.Contains(out, "[task.api") || !strings.Contains(out, `includes = { api = "services/api/Remfile", web = { path = "services/web/Remfile", vars = { VERSION = "${VERSION}-web" } } }`) {
+		t.Fatalf("Format() =\n%s", out)
+	}
+}
+
+func TestLoadIncludesReportsErrorsInIncludedFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Remfile":     "includes = { api = \"api/Remfile\" }\n\n[task.all]\ndeps = [\"api:biuld\"]\n",
+		"api/Remfile": "[task.build]\ndeps = [\"tset\"]\ncmd = \"x\"\n\n[task.test]\ncmd = \"y\"\n",
+	})
+
+	_, err := Load(filepath.Join(dir, "Remfile"))
+	if err == nil {
+		t.Fatal("Load() succeeded")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`Remfile:4:9: task "all" depends on undefined task "api:biuld"`,
+		`did you mean "api:build"?`,
+		filepath.Join("api", "Remfile") + `:2:9: task "api:build" depends on undefined task "api:tset"`,
+		`did you mean "api:test"?`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Fatalf("error =\n%s\nwant it to contain %q", msg, want)
+		}
+	}
+}
+
+func TestLoadIncludesDetectsCycles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"Remfile":   "includes = { a = \"a/Remfile\" }\n[task.root]\ncmd = \"x\"\n",
+		"a/Remfile": "includes = { b = \"../b/Remfile\" }\n[task.a]\ncmd = \"x\"\n",
+		"b/Remfile": "includes = { a = \"../a/Remfile\" }\n[task.b]\ncmd = \"x\"\n",
+	})
+
+	_, err := Load(filepath.Join(dir, "Remfile"))
+	if err == nil {
+		t.Fatal("Load() succeeded")
+	}
+	want := "include cycle: " + strings.Join([]string{
+		filepath.Join(dir, "a", "Remfile"),
+		filepath.Join(dir, "b", "Remfile"),
+		filepath.Join(dir, "a", "Remfile"),
+	}, " -> ")
+	if !strings.Contains(err.Error(), want) {
+		t.Fatalf("error =\n%v\nwant it to contain %q", err, want)
+	}
+}
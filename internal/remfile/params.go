@@ -0,0 +1,193 @@
+package remfile
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"rem/internal/shellcfg"
+	"rem/internal/toml"
+)
+
+// Param is a named task parameter. Tasks refer to it as ${name}; the value
+// comes from `rem run task name=value` or falls back to Default.
+type Param struct {
+	Name    string
+	Default string
+	Desc    string
+}
+
+const (
+	ArgsVar    = "ARGS"
+	CLIArgsVar = "CLI_ARGS"
+)
+
+// parseParams accepts `{ pkg = "./..." }` and the long form
+// `{ pkg = { default = "./...", desc = "packages to test" } }`.
+func (f *File) parseParams(tbl *toml.Table) []Param {
+	params := make([]Param, 0, tbl.Len())
+	for _, name := range tbl.Keys() {
+		v, _ := tbl.Get(name)
+		if !isVarName(name) {
+			f.report(f.errorf(f.span(tbl.KeySpan(name)), "invalid parameter name %q", name))
+			continue
+		}
+		p := Param{Name: name}
+		fields, ok := v.(*toml.Table)
+		if !ok {
+			var err error
+			if p.Default, err = scalarValue(v); err != nil {
+				f.report(f.fieldError(tbl, name, err, "parameter %q", name))
+				continue
+			}
+			params = append(params, p)
+			continue
+		}
+
+		for _, key := range fields.Keys() {
+			if key != "default" && key != "desc" {
+				e := f.errorf(f.span(fields.KeySpan(key)), "parameter %q: unknown field %q", name, key)
+				e.Hint = didYouMean(key, []string{"default", "desc"})
+				f.report(e)
+				continue
+			}
+			fv, _ := fields.Get(key)
+			val, err := scalarValue(fv)
+			if err != nil {
+				f.report(f.fieldError(fields, key, err, "parameter %q %s", name, key))
+				continue
+			}
+			if key == "default" {
+				p.Default = val
+			} else {
+				p.Desc = val
+			}
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func formatParams(params []Param) string {
+	parts := make([]string, 0, len(params))
+	for _, p := range params {
+		if p.Desc == "" {
+			parts = append(parts, formatKey(p.Name)+" = "+quoteTOML(p.Default))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s = { default = %s, desc = %s }", formatKey(p.Name), quoteTOML(p.Default), quoteTOML(p.Desc)))
+	}
+	return "{ " + strings.Join(parts, ", ") + " }"
+}
+
+// Usage renders a task with its parameters for listings, e.g.
+// `test [pkg=./...]`.
+func (t *Task) Usage() string {
+	var b strings.Builder
+	b.WriteString(t.Name)
+	for _, p := range t.Params {
+		b.WriteString(" [")
+		b.WriteString(p.Name)
+		b.WriteString("=")
+		b.WriteString(p.Default)
+		b.WriteString("]")
+	}
+	return b.String()
+}
+
+// SetParams binds parameter values and the arguments after `--` to a task,
+// as in `rem run test pkg=./internal/engine -- -run TestCycle`. Every value
+// must name a declared parameter. A nil args leaves ${ARGS} empty.
+func (f *File) SetParams(taskName string, values map[string]string, args []string) error {
+	t, ok := f.Tasks[taskName]
+	if !ok {
+		return f.NoSuchTask("target", taskName)
+	}
+	for name := range values {
+		if t.param(name) == nil {
+			return fmt.Errorf("task %q has no parameter %q%s", taskName, name, t.paramHint())
+		}
+	}
+	t.values = values
+	t.args = args
+	return nil
+}
+
+func (t *Task) param(name string) *Param {
+	for i := range t.Params {
+		if t.Params[i].Name == name {
+			return &t.Params[i]
+		}
+	}
+	return nil
+}
+
+func (t *Task) paramHint() string {
+	if len(t.Params) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(t.Params))
+	for _, p := range t.Params {
+		names = append(names, p.Name)
+	}
+	sort.Strings(names)
+	return " (available: " + strings.Join(names, ", ") + ")"
+}
+
+// ExpandTask expands input like ExpandString, with the task's parameters
+// and ${ARGS}/${CLI_ARGS} taking precedence over vars.
+func (f *File) ExpandTask(t *Task, input string) string {
+	if t == nil {
+		return f.ExpandString(input)
+	}
+	if !f.local(t) {
+		return t.file.ExpandTask(t, input)
+	}
+	out, _ := expandTemplate(input, false, func(expr string) (string, bool, error) {
+		name, fallback, hasFallback := parseVarExpr(expr)
+		if !isVarName(name) {
+			return "", false, nil
+		}
+		if p := t.param(name); p != nil {
+			if v, ok := t.values[name]; ok {
+				return f.ExpandString(v), true, nil
+			}
+			return f.ExpandString(p.Default), true, nil
+		}
+		if (name == ArgsVar || name == CLIArgsVar) && t.args != nil {
+			return shellcfg.QuoteArgs(t.args), true, nil
+		}
+		if val, ok := f.Vars[name]; ok {
+			return val, true, nil
+		}
+		if envVal, ok := os.LookupEnv(name); ok {
+			return envVal, true, nil
+		}
+		if i := envIndex(t.dotenv, name); i >= 0 {
+			return t.dotenv[i].Value, true, nil
+		}
+		if i := envIndex(f.dotenv, name); i >= 0 {
+			return f.dotenv[i].Value, true, nil
+		}
+		if hasFallback {
+			return f.ExpandTask(t, fallback), true, nil
+		}
+		if name == ArgsVar || name == CLIArgsVar {
+			return "", true, nil
+		}
+		return "", false, nil
+	})
+	return out
+}
+
+func (f *File) ExpandTaskList(t *Task, values []string) []string {
+	out := make([]string, 0, len(values))
+	for _, v := range values {
+		exp := strings.TrimSpace(f.ExpandTask(t, v))
+		if exp != "" {
+			out = append(out, exp)
+		}
+	}
+	return out
+}
@@ -0,0 +1,52 @@
+package remfile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseParamsAndExpandTask(t *testing.T) {
+	content := `
+default = "test"
+
+[vars]
+GO = "go"
+
+[task.test]
+params = { pkg = "./...", race = { default = "", desc = "set to -race to enable the detector" } }
+cmds = ["${GO} test ${race} ${pkg} ${ARGS}"]
+`
+	rf, err := Parse(bytes.NewBufferString(strings.TrimSpace(content)))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	task := rf.Tasks["test"]
+	if len(task.Params) != 2 || task.Params[1].Desc != "set to -race to enable the detector" {
+		t.Fatalf("params = %+v", task.Params)
+	}
+	if got := task.Usage(); got != "test [pkg=./...] [race=]" {
+		t.Fatalf("Usage() = %q", got)
+	}
+
+	if got := rf.ExpandTask(task, task.Cmds[0]); got != "go test  ./... " {
+		t.Fatalf("default expansion = %q", got)
+	}
+
+	if err := rf.SetParams("test", map[string]string{"pkg": "./internal/engine"}, []string{"-run", "TestCycle"}); err != nil {
+		t.Fatalf("SetParams() error: %v", err)
+	}
+	if got := rf.ExpandTask(task, task.Cmds[0]); got != "go test  ./internal/engine -run TestCycle" {
+		t.Fatalf("bound expansion = %q", got)
+	}
+
+	err = rf.SetParams("test", map[string]string{"pakg": "x"}, nil)
+	if err == nil || !strings.Contains(err.Error(), `task "test" has no parameter "pakg" (available: pkg, race)`) {
+		t.Fatalf("expected unknown parameter error, got %v", err)
+	}
+
+	formatted := Format(rf)
+	if !strings.Contains(formatted, `params = { pkg = "./...", race = { default = "", desc = "set to -race to enable the detector" } }`) {
+		t.Fatalf("formatted output lost params:\n%s", formatted)
+	}
+}
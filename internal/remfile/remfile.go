@@ -9,7 +9,10 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
+
+	"rem/internal/toml"
 )
 
 type Task struct {
@@ -18,8 +21,61 @@ type Task struct {
 	Deps    []string
 	Inputs  []string
 	Outputs []string
+	Depfile string
 	Cmds    []string
 	Dir     string
+	Check   string
+	Verify  string
+	Timeout time.Duration
+	Retries int
+	Backoff time.Duration
+	Restart bool
+	Params  []Param
+	Env     []EnvVar
+	Dotenv  []string
+
+	// Span is where the task's name was written; DepSpans and CmdSpans
+	// line up with Deps and Cmds.
+	Span     Span
+	DepSpans []Span
+	CmdSpans []Span
+
+	dotenv      []EnvVar
+	dotenvSpans []Span
+	values      map[string]string
+	args        []string
+	file        *File
+}
+
+const (
+	CheckHash  = "hash"
+	CheckMtime = "mtime"
+)
+
+const (
+	VerifyStrict = "strict"
+	VerifyWarn   = "warn"
+	VerifyOff    = "off"
+)
+
+const (
+	OutputInterleaved = "interleaved"
+	OutputPrefixed    = "prefixed"
+	OutputGrouped     = "grouped"
+)
+
+// EnvVar is one entry of an `[env]` table or a task `env` table. Values
+// are expanded like cmds before they reach the command environment.
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+type CacheSettings struct {
+	Dir      string
+	MaxSize  string
+	Remote   string
+	ReadOnly bool
 }
 
 type File struct {
@@ -31,223 +87,523 @@ type File struct {
 	Default  string
 	Order    []string
 	Tasks    map[string]*Task
+	Cache    CacheSettings
+	Output   string
+	Env      []EnvVar
+	CleanEnv bool
+	KeepEnv  []string
+	Dotenv   []string
+
+	DefaultSpan Span
+	VarSpans    map[string]Span
+	// Warnings are the problems found while loading that do not stop the
+	// Remfile from being used.
+	Warnings Diagnostics
+
+	dotenv       []EnvVar
+	dotenvSpans  []Span
+	diags        Diagnostics
+	includeDiags Diagnostics
+	includes     []*include
+	name         string
+	lines        []string
 }
 
 func Load(path string) (*File, error) {
-	f, err := os.Open(path)
+	rf, diags, err := Diagnose(path)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-
-	rf, err := Parse(f)
-	if err != nil {
+	if err := diags.Err(); err != nil {
 		return nil, err
 	}
+	return rf, nil
+}
 
+func Parse(r io.Reader) (*File, error) {
+	return parse(r, "", "Remfile")
+}
+
+// Diagnose loads a Remfile like Load but keeps going past problems, so
+// `rem check` and `rem doctor` can report every error and warning at once.
+// The returned error is for files that cannot be read at all; the File is
+// nil when diags holds errors.
+func Diagnose(path string) (*File, Diagnostics, error) {
 	abs, err := filepath.Abs(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	rf, diags := parseTOML(normalizeNewlines(content), filepath.Dir(abs), path, loadScope{chain: []string{abs}, names: []string{path}})
+	if diags.HasErrors() {
+		return nil, diags, nil
 	}
 	rf.Path = abs
-	rf.Dir = filepath.Dir(abs)
-	return rf, nil
+	return rf, diags, nil
 }
 
-func Parse(r io.Reader) (*File, error) {
+// parse reads a Remfile. With a dir, dotenv files are read relative to it
+// before vars are resolved. Errors are reported against name.
+func parse(r io.Reader, dir string, name string) (*File, error) {
 	content, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
-	text := strings.ReplaceAll(string(content), "\r\n", "\n")
-
-	rf, err := parseTOML(text)
-	if err != nil {
-		return nil, fmt.Errorf("Remfile parse failed: %w", err)
+	rf, diags := parseTOML(normalizeNewlines(content), dir, name, loadScope{})
+	if err := diags.Err(); err != nil {
+		return nil, err
 	}
 	return rf, nil
 }
 
-func parseTOML(text string) (*File, error) {
+func normalizeNewlines(content []byte) string {
+	return strings.ReplaceAll(string(content), "\r\n", "\n")
+}
+
+// parseTOML builds a File from Remfile source, reporting every problem it
+// finds rather than stopping at the first. A TOML syntax error is the only
+// problem that ends parsing early.
+func parseTOML(text string, dir string, name string, sc loadScope) (*File, Diagnostics) {
 	rf := &File{
-		Vars:    make(map[string]string),
-		RawVars: make(map[string]string),
-		Tasks:   make(map[string]*Task),
+		Dir:      dir,
+		Vars:     make(map[string]string),
+		RawVars:  make(map[string]string),
+		Tasks:    make(map[string]*Task),
+		VarSpans: make(map[string]Span),
+		name:     name,
+		lines:    strings.Split(text, "\n"),
 	}
-	rawVars := make(map[string]string)
 
-	const (
-		sectionRoot = iota
-		sectionVars
-		sectionTask
-	)
-	section := sectionRoot
-	currentTask := ""
-
-	lines := strings.Split(text, "\n")
-	for i := 0; i < len(lines); i++ {
-		raw := lines[i]
-		line := strings.TrimSpace(stripInlineComment(raw))
-		if line == "" {
-			continue
+	doc, err := toml.Decode([]byte(text))
+	if err != nil {
+		var terr *toml.Error
+		if errors.As(err, &terr) {
+			pos := terr.Pos
+			rf.report(rf.errorf(Span{File: name, Line: pos.Line, Col: pos.Col, EndLine: pos.Line, EndCol: pos.Col + 1}, "%s", terr.Msg))
+		} else {
+			rf.report(&Error{Severity: SeverityError, Msg: err.Error()})
 		}
+		return rf, rf.diags
+	}
+	rawVars := make(map[string]string)
 
-		if strings.HasPrefix(line, "[") {
-			name, ok := parseSection(line)
-			if !ok {
-				return nil, fmt.Errorf("line %d: invalid TOML section %q", i+1, line)
+	for _, key := range doc.Keys() {
+		v, _ := doc.Get(key)
+		switch key {
+		case "default":
+			if rf.Default, err = stringValue(v); err != nil {
+				rf.report(rf.fieldError(doc, key, err, "default"))
+				continue
+			}
+			rf.DefaultSpan = rf.span(doc.ValueSpan(key))
+		case "output":
+			if rf.Output, err = stringValue(v); err != nil {
+				rf.report(rf.fieldError(doc, key, err, "output"))
+				continue
+			}
+			if rf.Output != OutputInterleaved && rf.Output != OutputPrefixed && rf.Output != OutputGrouped {
+				e := rf.errorf(rf.span(doc.ValueSpan(key)), "output: expected %q, %q or %q, got %q", OutputInterleaved, OutputPrefixed, OutputGrouped, rf.Output)
+				e.Hint = didYouMean(rf.Output, []string{OutputInterleaved, OutputPrefixed, OutputGrouped})
+				rf.report(e)
+				rf.Output = ""
+			}
+		case "clean_env":
+			if rf.CleanEnv, err = boolValue(v); err != nil {
+				rf.report(rf.fieldError(doc, key, err, "clean_env"))
 			}
-			switch {
-			case name == "vars":
-				section = sectionVars
-				currentTask = ""
-			case strings.HasPrefix(name, "task."):
-				taskName := strings.TrimSpace(strings.TrimPrefix(name, "task."))
-				if !isTaskName(taskName) {
-					return nil, fmt.Errorf("line %d: invalid task name %q", i+1, taskName)
+		case "dotenv":
+			var spans []toml.Span
+			if rf.Dotenv, spans, err = listValue(v, doc.ValueSpan(key)); err != nil {
+				rf.report(rf.fieldError(doc, key, err, "dotenv"))
+				continue
+			}
+			for _, s := range spans {
+				rf.dotenvSpans = append(rf.dotenvSpans, rf.span(s))
+			}
+		case "keep_env":
+			if rf.KeepEnv, _, err = listValue(v, doc.ValueSpan(key)); err != nil {
+				rf.report(rf.fieldError(doc, key, err, "keep_env"))
+			}
+		case "vars":
+			vars, err := tableValue(v)
+			if err != nil {
+				rf.report(rf.fieldError(doc, key, err, "vars"))
+				continue
+			}
+			for _, name := range vars.Keys() {
+				val, _ := vars.Get(name)
+				if !isVarName(name) {
+					rf.report(rf.errorf(rf.span(vars.KeySpan(name)), "invalid variable name %q", name))
+					continue
 				}
-				if _, exists := rf.Tasks[taskName]; exists {
-					return nil, fmt.Errorf("line %d: duplicate task section %q", i+1, taskName)
+				parsed, err := scalarValue(val)
+				if err != nil {
+					rf.report(rf.fieldError(vars, name, err, "var %q", name))
+					continue
 				}
-				t := &Task{Name: taskName}
-				rf.Tasks[taskName] = t
-				rf.Order = append(rf.Order, taskName)
-				section = sectionTask
-				currentTask = taskName
-			default:
-				return nil, fmt.Errorf("line %d: unsupported section %q", i+1, name)
+				rawVars[name] = parsed
+				rf.RawVars[name] = parsed
+				rf.VarOrder = append(rf.VarOrder, name)
+				rf.VarSpans[name] = rf.span(vars.ValueSpan(name))
 			}
+		case "env":
+			env, err := tableValue(v)
+			if err != nil {
+				rf.report(rf.fieldError(doc, key, err, "env"))
+				continue
+			}
+			rf.Env = rf.parseEnvTable(env)
+		case "cache":
+			cache, err := tableValue(v)
+			if err != nil {
+				rf.report(rf.fieldError(doc, key, err, "cache"))
+				continue
+			}
+			rf.parseCache(cache)
+		case "includes":
+			incs, err := tableValue(v)
+			if err != nil {
+				rf.report(rf.fieldError(doc, key, err, "includes"))
+				continue
+			}
+			rf.parseIncludes(incs)
+		case "task":
+			tasks, err := tableValue(v)
+			if err != nil {
+				rf.report(rf.fieldError(doc, key, err, "task"))
+				continue
+			}
+			rf.parseTasks(tasks)
+		default:
+			if _, ok := v.(*toml.Table); ok {
+				e := rf.errorf(rf.span(doc.KeySpan(key)), "unsupported section %q", key)
+				e.Hint = didYouMean(key, []string{"vars", "env", "cache", "includes", "task"})
+				rf.report(e)
+				continue
+			}
+			e := rf.errorf(rf.span(doc.KeySpan(key)), "unsupported top-level key %q", key)
+			e.Hint = didYouMean(key, []string{"default", "output", "clean_env", "dotenv", "keep_env", "includes"})
+			rf.report(e)
+		}
+	}
+
+	rf.applyVarOverrides(rawVars, sc.vars)
+	if dir != "" {
+		if err := rf.readDotenvFiles(rawVars); err != nil {
+			var e *Error
+			if !errors.As(err, &e) {
+				e = &Error{Severity: SeverityError, Msg: err.Error()}
+			}
+			rf.report(e)
+		}
+	}
+	finalizeFile(rf, rawVars, sc)
+	sort.SliceStable(rf.diags, func(i, j int) bool {
+		return rf.diags[i].Span.before(rf.diags[j].Span)
+	})
+	return rf, append(rf.diags, rf.includeDiags...)
+}
+
+// fieldError reports a bad value for key in tbl, pointing at the offending
+// array item when there is one.
+func (f *File) fieldError(tbl *toml.Table, key string, err error, format string, args ...any) *Error {
+	span := tbl.ValueSpan(key)
+	var ie *itemError
+	if errors.As(err, &ie) {
+		span = ie.span
+	}
+	return f.errorf(f.span(span), "%s: %v", fmt.Sprintf(format, args...), err)
+}
+
+var cacheFields = []string{"dir", "max_size", "remote", "read_only"}
+
+func (f *File) parseCache(tbl *toml.Table) {
+	c := &f.Cache
+	for _, key := range tbl.Keys() {
+		v, _ := tbl.Get(key)
+		var err error
+		switch key {
+		case "dir":
+			c.Dir, err = stringValue(v)
+		case "max_size":
+			c.MaxSize, err = scalarValue(v)
+		case "remote":
+			c.Remote, err = stringValue(v)
+		case "read_only":
+			c.ReadOnly, err = boolValue(v)
+		default:
+			e := f.errorf(f.span(tbl.KeySpan(key)), "unknown cache field %q", key)
+			e.Hint = didYouMean(key, cacheFields)
+			f.report(e)
 			continue
 		}
+		if err != nil {
+			f.report(f.fieldError(tbl, key, err, "cache %s", key))
+		}
+	}
+}
+
+// taskTables are the task fields that hold tables; any other table under
+// a task is a task of its own, so `[task.docs.build]` defines "docs.build".
+var taskTables = map[string]bool{"env": true, "params": true}
+
+var taskFields = []string{
+	"desc", "dir", "depfile", "uptodate", "verify", "timeout", "retries", "backoff", "restart",
+	"dotenv", "env", "params", "deps", "inputs", "outputs", "cmd", "cmds",
+}
+
+type taskTable struct {
+	name  string
+	table *toml.Table
+	span  toml.Span
+}
 
-		key, val, ok := splitKV(line)
+func (f *File) parseTasks(tasks *toml.Table) {
+	var found []taskTable
+	for _, name := range tasks.Keys() {
+		v, _ := tasks.Get(name)
+		tbl, ok := v.(*toml.Table)
 		if !ok {
-			return nil, fmt.Errorf("line %d: invalid TOML key-value %q", i+1, line)
-		}
-		if strings.HasPrefix(strings.TrimSpace(val), "[") {
-			balance := bracketDelta(val)
-			for balance > 0 {
-				i++
-				if i >= len(lines) {
-					return nil, fmt.Errorf("line %d: unterminated array value for key %q", i+1, key)
-				}
-				next := strings.TrimSpace(stripInlineComment(lines[i]))
-				if next == "" {
-					continue
-				}
-				val += " " + next
-				balance += bracketDelta(next)
-			}
+			f.report(f.errorf(f.span(tasks.ValueSpan(name)), "task %q: expected table, got %s", name, toml.TypeName(v)))
+			continue
+		}
+		found = collectTasks(name, tbl, tasks.KeySpan(name), found)
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].table.Pos.Before(found[j].table.Pos)
+	})
+
+	for _, tt := range found {
+		span := f.span(tt.span)
+		if !isTaskName(tt.name) {
+			f.report(f.errorf(span, "invalid task name %q", tt.name))
+			continue
+		}
+		if _, exists := f.Tasks[tt.name]; exists {
+			f.report(f.errorf(span, "duplicate task section %q", tt.name))
+			continue
 		}
+		t := f.parseTask(tt.name, tt.table)
+		t.Span = span
+		f.Tasks[tt.name] = t
+		f.Order = append(f.Order, tt.name)
+	}
+}
 
-		switch section {
-		case sectionRoot:
-			if key != "default" {
-				return nil, fmt.Errorf("line %d: unsupported top-level key %q", i+1, key)
+// collectTasks appends tbl as task name unless it only exists to hold
+// nested tasks, then the nested tasks themselves.
+func collectTasks(name string, tbl *toml.Table, span toml.Span, out []taskTable) []taskTable {
+	isTask := !tbl.Implicit()
+	for _, key := range tbl.Keys() {
+		v, _ := tbl.Get(key)
+		if _, ok := v.(*toml.Table); !ok || taskTables[key] {
+			isTask = true
+		}
+	}
+	if isTask {
+		out = append(out, taskTable{name: name, table: tbl, span: span})
+	}
+	for _, key := range tbl.Keys() {
+		v, _ := tbl.Get(key)
+		if sub, ok := v.(*toml.Table); ok && !taskTables[key] {
+			out = collectTasks(name+"."+key, sub, tbl.KeySpan(key), out)
+		}
+	}
+	return out
+}
+
+// parseTask reports bad fields and leaves them unset, so later checks
+// still see the rest of the task.
+func (f *File) parseTask(name string, tbl *toml.Table) *Task {
+	t := &Task{Name: name, file: f}
+	for _, key := range tbl.Keys() {
+		v, _ := tbl.Get(key)
+		if _, ok := v.(*toml.Table); ok && !taskTables[key] {
+			continue
+		}
+
+		var err error
+		var spans []toml.Span
+		switch key {
+		case "desc":
+			t.Desc, err = stringValue(v)
+		case "dir":
+			t.Dir, err = stringValue(v)
+		case "depfile":
+			t.Depfile, err = stringValue(v)
+		case "uptodate":
+			if t.Check, err = stringValue(v); err == nil && t.Check != CheckHash && t.Check != CheckMtime {
+				err = fmt.Errorf("expected %q or %q, got %q", CheckHash, CheckMtime, t.Check)
+				t.Check = ""
 			}
-			parsed, err := parseTOMLStringValue(val)
-			if err != nil {
-				return nil, fmt.Errorf("line %d: default: %w", i+1, err)
+		case "verify":
+			if t.Verify, err = stringValue(v); err == nil && t.Verify != VerifyStrict && t.Verify != VerifyWarn && t.Verify != VerifyOff {
+				err = fmt.Errorf("expected %q, %q or %q, got %q", VerifyStrict, VerifyWarn, VerifyOff, t.Verify)
+				t.Verify = ""
 			}
-			rf.Default = parsed
-		case sectionVars:
-			if !isVarName(key) {
-				return nil, fmt.Errorf("line %d: invalid variable name %q", i+1, key)
+		case "timeout":
+			t.Timeout, err = durationValue(v)
+		case "retries":
+			if t.Retries, err = intValue(v); err == nil && t.Retries < 0 {
+				err = errors.New("must not be negative")
+				t.Retries = 0
 			}
-			if _, exists := rawVars[key]; exists {
-				return nil, fmt.Errorf("line %d: duplicate var %q", i+1, key)
+		case "backoff":
+			t.Backoff, err = durationValue(v)
+		case "restart":
+			t.Restart, err = boolValue(v)
+		case "dotenv":
+			if t.Dotenv, spans, err = listValue(v, tbl.ValueSpan(key)); err == nil {
+				for _, s := range spans {
+					t.dotenvSpans = append(t.dotenvSpans, f.span(s))
+				}
 			}
-			parsed, err := parseTOMLStringValue(val)
-			if err != nil {
-				return nil, fmt.Errorf("line %d: var %q: %w", i+1, key, err)
+		case "env":
+			var env *toml.Table
+			if env, err = tableValue(v); err == nil {
+				t.Env = f.parseEnvTable(env)
 			}
-			rawVars[key] = parsed
-			rf.RawVars[key] = parsed
-			rf.VarOrder = append(rf.VarOrder, key)
-		case sectionTask:
-			t := rf.Tasks[currentTask]
-			switch key {
-			case "desc":
-				parsed, err := parseTOMLStringValue(val)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: task %q desc: %w", i+1, currentTask, err)
-				}
-				t.Desc = parsed
-			case "dir":
-				parsed, err := parseTOMLStringValue(val)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: task %q dir: %w", i+1, currentTask, err)
-				}
-				t.Dir = parsed
-			case "deps":
-				items, err := parseTOMLListValue(val)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: task %q deps: %w", i+1, currentTask, err)
-				}
-				t.Deps = append(t.Deps, items...)
-			case "inputs":
-				items, err := parseTOMLListValue(val)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: task %q inputs: %w", i+1, currentTask, err)
-				}
-				t.Inputs = append(t.Inputs, items...)
-			case "outputs":
-				items, err := parseTOMLListValue(val)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: task %q outputs: %w", i+1, currentTask, err)
-				}
-				t.Outputs = append(t.Outputs, items...)
-			case "cmd":
-				parsed, err := parseTOMLStringValue(val)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: task %q cmd: %w", i+1, currentTask, err)
-				}
-				if parsed != "" {
-					t.Cmds = append(t.Cmds, parsed)
+		case "params":
+			var params *toml.Table
+			if params, err = tableValue(v); err == nil {
+				t.Params = f.parseParams(params)
+			}
+		case "deps":
+			if t.Deps, spans, err = listValue(v, tbl.ValueSpan(key)); err == nil {
+				for _, s := range spans {
+					t.DepSpans = append(t.DepSpans, f.span(s))
 				}
-			case "cmds":
-				items, err := parseTOMLStringArray(val)
-				if err != nil {
-					return nil, fmt.Errorf("line %d: task %q cmds: %w", i+1, currentTask, err)
+			}
+		case "inputs":
+			t.Inputs, _, err = listValue(v, tbl.ValueSpan(key))
+		case "outputs":
+			t.Outputs, _, err = listValue(v, tbl.ValueSpan(key))
+		case "cmd":
+			var cmd string
+			if cmd, err = stringValue(v); err == nil && cmd != "" {
+				t.Cmds = append(t.Cmds, cmd)
+				t.CmdSpans = append(t.CmdSpans, f.span(tbl.ValueSpan(key)))
+			}
+		case "cmds":
+			var cmds []string
+			if cmds, spans, err = stringArrayValue(v); err == nil {
+				t.Cmds = append(t.Cmds, cmds...)
+				for _, s := range spans {
+					t.CmdSpans = append(t.CmdSpans, f.span(s))
 				}
-				t.Cmds = append(t.Cmds, items...)
-			default:
-				return nil, fmt.Errorf("line %d: unknown task field %q", i+1, key)
 			}
+		default:
+			e := f.errorf(f.span(tbl.KeySpan(key)), "unknown task field %q", key)
+			e.Hint = didYouMean(key, taskFields)
+			f.report(e)
+			continue
+		}
+		if err != nil {
+			f.report(f.fieldError(tbl, key, err, "task %q %s", name, key))
 		}
 	}
-
-	return finalizeFile(rf, rawVars)
+	return t
 }
 
-func finalizeFile(rf *File, rawVars map[string]string) (*File, error) {
-	resolvedVars, err := resolveVars(rawVars)
+// finalizeFile resolves vars, loads includes and checks that every task
+// referenced exists. Deps are checked once, by the outermost Remfile, since
+// an included one may depend on tasks it cannot see.
+func finalizeFile(rf *File, rawVars map[string]string, sc loadScope) {
+	resolvedVars, err := resolveVars(rawVars, rf.lookupEnv)
 	if err != nil {
-		return nil, err
+		for _, err := range splitErrors(err) {
+			rf.report(rf.varError(err))
+		}
 	}
 	rf.Vars = resolvedVars
+	ownTasks := len(rf.Order)
+	rf.loadIncludes(sc)
+
+	for _, name := range rf.Order[:ownTasks] {
+		task := rf.Tasks[name]
+		seen := make(map[string]bool, len(task.Deps))
+		for i, dep := range task.Deps {
+			if seen[dep] && i < len(task.DepSpans) {
+				rf.warnf(task.DepSpans[i], "task %q lists dependency %q more than once", name, dep)
+			}
+			seen[dep] = true
+		}
+		if len(task.Cmds) == 0 && len(task.Deps) == 0 {
+			rf.warnf(task.Span, "task %q has no cmds and no deps, so it does nothing", name)
+		}
+	}
 
 	if len(rf.Tasks) == 0 {
-		return nil, errors.New("Remfile has no tasks")
+		if sc.included {
+			rf.report(rf.errorf(Span{File: rf.name}, "included Remfile has no tasks"))
+		} else if !rf.diags.HasErrors() {
+			rf.report(&Error{Severity: SeverityError, Msg: "Remfile has no tasks"})
+		}
+		return
 	}
 	if rf.Default == "" {
 		rf.Default = rf.Order[0]
 	}
+	if sc.included {
+		return
+	}
 	defaultTask := rf.DefaultTarget()
 	if _, ok := rf.Tasks[defaultTask]; !ok {
-		return nil, fmt.Errorf("default task %q is not defined", defaultTask)
+		e := rf.errorf(rf.DefaultSpan, "default task %q is not defined", defaultTask)
+		e.Hint = didYouMean(defaultTask, rf.Order)
+		rf.report(e)
 	}
 	for _, name := range rf.Order {
 		task := rf.Tasks[name]
-		for _, dep := range task.Deps {
-			depName := rf.ExpandString(dep)
-			if _, ok := rf.Tasks[depName]; !ok {
-				return nil, fmt.Errorf("task %q depends on undefined task %q", name, depName)
+		tf := rf.TaskFile(task)
+		for i, dep := range task.Deps {
+			task.Deps[i] = strings.TrimPrefix(dep, ":")
+			depName := tf.ExpandString(task.Deps[i])
+			if _, ok := rf.Tasks[depName]; ok {
+				continue
+			}
+			var span Span
+			if i < len(task.DepSpans) {
+				span = task.DepSpans[i]
+			}
+			e := tf.errorf(span, "task %q depends on undefined task %q", name, depName)
+			e.Hint = didYouMean(depName, rf.Order)
+			if tf == rf {
+				rf.report(e)
+			} else {
+				rf.includeDiags = append(rf.includeDiags, e)
 			}
 		}
 	}
+}
 
-	return rf, nil
+// splitErrors undoes errors.Join.
+func splitErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// varError points a resolveVars failure at the var it happened in and,
+// for an unknown ${REF}, at the reference itself.
+func (f *File) varError(err error) *Error {
+	var ve *varError
+	if !errors.As(err, &ve) {
+		return &Error{Severity: SeverityError, Msg: err.Error()}
+	}
+	span := f.VarSpans[ve.name]
+	var ue *unresolvedError
+	if !errors.As(ve.err, &ue) {
+		return f.errorf(span, "%v", ve)
+	}
+	e := f.errorf(f.narrow(span, ue.token), "%v", ve)
+	name, _, _ := parseVarExpr(strings.TrimSuffix(strings.TrimPrefix(ue.token, "${"), "}"))
+	e.Hint = didYouMean(name, f.VarOrder)
+	return e
 }
 
 func Format(rf *File) string {
@@ -256,6 +612,29 @@ func Format(rf *File) string {
 	b.WriteString("default = ")
 	b.WriteString(quoteTOML(rf.Default))
 	b.WriteString("\n")
+	if rf.Output != "" {
+		b.WriteString("output = ")
+		b.WriteString(quoteTOML(rf.Output))
+		b.WriteString("\n")
+	}
+	if rf.CleanEnv {
+		b.WriteString("clean_env = true\n")
+	}
+	if len(rf.Dotenv) > 0 {
+		b.WriteString("dotenv = ")
+		b.WriteString(formatTOMLArray(rf.Dotenv))
+		b.WriteString("\n")
+	}
+	if len(rf.KeepEnv) > 0 {
+		b.WriteString("keep_env = ")
+		b.WriteString(formatTOMLArray(rf.KeepEnv))
+		b.WriteString("\n")
+	}
+	if len(rf.includes) > 0 {
+		b.WriteString("includes = ")
+		b.WriteString(formatIncludes(rf.includes))
+		b.WriteString("\n")
+	}
 
 	writeVars := rf.VarOrder
 	if len(writeVars) == 0 && len(rf.Vars) > 0 {
@@ -273,17 +652,52 @@ func Format(rf *File) string {
 			if val == "" {
 				val = rf.Vars[name]
 			}
-			b.WriteString(name)
+			b.WriteString(formatKey(name))
 			b.WriteString(" = ")
 			b.WriteString(quoteTOML(val))
 			b.WriteString("\n")
 		}
 	}
 
+	if len(rf.Env) > 0 {
+		b.WriteString("\n[env]\n")
+		for _, e := range rf.Env {
+			b.WriteString(formatKey(e.Name))
+			b.WriteString(" = ")
+			b.WriteString(quoteTOML(e.Value))
+			b.WriteString("\n")
+		}
+	}
+
+	if rf.Cache != (CacheSettings{}) {
+		b.WriteString("\n[cache]\n")
+		if rf.Cache.Dir != "" {
+			b.WriteString("dir = ")
+			b.WriteString(quoteTOML(rf.Cache.Dir))
+			b.WriteString("\n")
+		}
+		if rf.Cache.MaxSize != "" {
+			b.WriteString("max_size = ")
+			b.WriteString(quoteTOML(rf.Cache.MaxSize))
+			b.WriteString("\n")
+		}
+		if rf.Cache.Remote != "" {
+			b.WriteString("remote = ")
+			b.WriteString(quoteTOML(rf.Cache.Remote))
+			b.WriteString("\n")
+		}
+		if rf.Cache.ReadOnly {
+			b.WriteString("read_only = true\n")
+		}
+	}
+
 	for _, name := range rf.Order {
 		t := rf.Tasks[name]
+		if !rf.local(t) {
+			continue
+		}
 		b.WriteString("\n[task.")
-		b.WriteString(name)
+		b.WriteString(formatTaskKey(name))
 		b.WriteString("]\n")
 
 		if t.Desc != "" {
@@ -306,11 +720,59 @@ func Format(rf *File) string {
 			b.WriteString(formatTOMLArray(t.Outputs))
 			b.WriteString("\n")
 		}
+		if t.Depfile != "" {
+			b.WriteString("depfile = ")
+			b.WriteString(quoteTOML(t.Depfile))
+			b.WriteString("\n")
+		}
 		if t.Dir != "" {
 			b.WriteString("dir = ")
 			b.WriteString(quoteTOML(t.Dir))
 			b.WriteString("\n")
 		}
+		if t.Check != "" {
+			b.WriteString("uptodate = ")
+			b.WriteString(quoteTOML(t.Check))
+			b.WriteString("\n")
+		}
+		if t.Verify != "" {
+			b.WriteString("verify = ")
+			b.WriteString(quoteTOML(t.Verify))
+			b.WriteString("\n")
+		}
+		if t.Timeout > 0 {
+			b.WriteString("timeout = ")
+			b.WriteString(quoteTOML(formatDuration(t.Timeout)))
+			b.WriteString("\n")
+		}
+		if t.Retries > 0 {
+			b.WriteString("retries = ")
+			b.WriteString(strconv.Itoa(t.Retries))
+			b.WriteString("\n")
+		}
+		if t.Backoff > 0 {
+			b.WriteString("backoff = ")
+			b.WriteString(quoteTOML(formatDuration(t.Backoff)))
+			b.WriteString("\n")
+		}
+		if t.Restart {
+			b.WriteString("restart = true\n")
+		}
+		if len(t.Dotenv) > 0 {
+			b.WriteString("dotenv = ")
+			b.WriteString(formatTOMLArray(t.Dotenv))
+			b.WriteString("\n")
+		}
+		if len(t.Env) > 0 {
+			b.WriteString("env = ")
+			b.WriteString(formatEnvTable(t.Env))
+			b.WriteString("\n")
+		}
+		if len(t.Params) > 0 {
+			b.WriteString("params = ")
+			b.WriteString(formatParams(t.Params))
+			b.WriteString("\n")
+		}
 		if len(t.Cmds) > 0 {
 			b.WriteString("cmds = ")
 			b.WriteString(formatTOMLArray(t.Cmds))
@@ -326,11 +788,45 @@ func Format(rf *File) string {
 }
 
 func (f *File) ExpandString(input string) string {
-	return expandStringLoose(input, f.Vars)
+	return expandStringLoose(input, f.Vars, f.lookupEnv)
 }
 
 func (f *File) ExpandList(values []string) []string {
-	return expandListLoose(values, f.Vars)
+	return expandListLoose(values, f.Vars, f.lookupEnv)
+}
+
+func (f *File) ReferencedVars(values ...string) []string {
+	seen := make(map[string]bool)
+	var walk func(string)
+	walk = func(input string) {
+		_, _ = expandTemplate(input, false, func(expr string) (string, bool, error) {
+			name, fallback, hasFallback := parseVarExpr(expr)
+			if !isVarName(name) {
+				return "", false, nil
+			}
+			if hasFallback {
+				walk(fallback)
+			}
+			if seen[name] {
+				return "", false, nil
+			}
+			seen[name] = true
+			if raw, ok := f.RawVars[name]; ok {
+				walk(raw)
+			}
+			return "", false, nil
+		})
+	}
+	for _, v := range values {
+		walk(v)
+	}
+
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
 }
 
 func (f *File) DefaultTarget() string {
@@ -353,12 +849,29 @@ func (f *File) ApplyOverrides(overrides map[string]string) error {
 		raw[k] = v
 	}
 
-	resolved, err := resolveVars(raw)
+	if f.Dir != "" {
+		// Dotenv paths may use the overridden vars.
+		if err := f.readDotenvFiles(raw); err != nil {
+			return err
+		}
+	}
+	resolved, err := resolveVars(raw, f.lookupEnv)
 	if err != nil {
 		return err
 	}
 	f.RawVars = raw
 	f.Vars = resolved
+
+	// Overrides passed down to included Remfiles may use the vars that
+	// just changed.
+	for _, inc := range f.includes {
+		if inc.file == nil || len(inc.vars) == 0 {
+			continue
+		}
+		if err := inc.file.ApplyOverrides(f.expandIncludeVars(inc)); err != nil {
+			return fmt.Errorf("include %q: %w", inc.name, err)
+		}
+	}
 	return nil
 }
 
@@ -382,7 +895,7 @@ cmds = ["go generate ./..."]
 [task.build]
 desc = "Build rem binary"
 deps = ["gen"]
-inputs = ["cmd/rem/main.go", "internal/*/*.go", "go.mod"]
+inputs = ["cmd/rem/main.go", "internal/**/*.go", "!*_test.go", "go.mod"]
 outputs = ["bin/${APP_NAME}"]
 cmds = [
   "mkdir -p bin",
@@ -477,6 +990,7 @@ Daily:
 - rem run <target>
 - rem format
 - rem format --check
+- rem format --diff
 
 Optional release flow:
 - rem run -D VERSION=v1.0.0 production
@@ -490,7 +1004,7 @@ Optional release flow:
 
 - Variable expansion: ${VAR} and ${VAR:-fallback}
 - Tasks without outputs behave like phony targets
-- rem format writes canonical TOML and may rewrite layout/comments
+- rem format keeps comments and key order; it only normalizes whitespace, quoting and array wrapping
 - rem doctor checks basic environment and Remfile health
 - Task shell follows $SHELL; set REM_SHELL=/path/to/shell to force shell
 - release-preflight expects clean tracked git changes; bypass with -D RELEASE_ALLOW_DIRTY=1
@@ -542,6 +1056,7 @@ cmds = ["go test ./..."]
 - rem run <target>
 - rem format
 - rem format --check
+- rem format --diff
 
 Опциони release ток:
 - rem run -D VERSION=v1.0.0 production
@@ -555,7 +1070,7 @@ cmds = ["go test ./..."]
 
 - Експанзија променљивих: ${VAR} и ${VAR:-fallback}
 - Task без outputs се понаша као phony target
-- rem format пише канонски TOML и може да промени распоред/коментаре
+- rem format чува коментаре и редослед кључева; мења само размаке, наводнике и прелом низова
 - rem doctor проверава основно окружење и здравље Remfile-а
 - Task shell прати $SHELL; постави REM_SHELL=/path/to/shell за форсирање shell-а
 - release-preflight очекује чисте tracked git измене; bypass: -D RELEASE_ALLOW_DIRTY=1
@@ -584,232 +1099,150 @@ func writeDocFile(path string, content string) error {
 	return os.WriteFile(path, []byte(content), 0o644)
 }
 
-func stripInlineComment(s string) string {
-	inSingle := false
-	inDouble := false
-	escaped := false
-
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if inDouble {
-			if escaped {
-				escaped = false
-				continue
-			}
-			if c == '\\' {
-				escaped = true
-				continue
-			}
-			if c == '"' {
-				inDouble = false
-			}
-			continue
-		}
-		if inSingle {
-			if c == '\'' {
-				inSingle = false
-			}
-			continue
-		}
-
-		if c == '"' {
-			inDouble = true
-			continue
-		}
-		if c == '\'' {
-			inSingle = true
-			continue
-		}
-		if c == '#' {
-			return s[:i]
-		}
+func tableValue(v any) (*toml.Table, error) {
+	t, ok := v.(*toml.Table)
+	if !ok {
+		return nil, fmt.Errorf("expected table, got %s", toml.TypeName(v))
 	}
-	return s
+	return t, nil
 }
 
-func parseSection(line string) (string, bool) {
-	if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
-		return "", false
+func stringValue(v any) (string, error) {
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected string, got %s", toml.TypeName(v))
 	}
-	inner := strings.TrimSpace(line[1 : len(line)-1])
-	if inner == "" {
-		return "", false
-	}
-	return inner, true
+	return s, nil
 }
 
-func parseTOMLStringValue(v string) (string, error) {
-	v = strings.TrimSpace(v)
-	if v == "" {
-		return "", fmt.Errorf("empty value")
+// scalarValue accepts strings and the numbers and booleans that vars and
+// env values are commonly written as, e.g. `CGO_ENABLED = 0`.
+func scalarValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
 	}
-	if strings.HasPrefix(v, "[") {
-		return "", fmt.Errorf("expected string value, got array")
+	return "", fmt.Errorf("expected string, got %s", toml.TypeName(v))
+}
+
+func boolValue(v any) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected true or false, got %s", toml.TypeName(v))
 	}
+	return b, nil
+}
 
-	if strings.HasPrefix(v, "\"") {
-		u, err := strconv.Unquote(v)
-		if err != nil {
-			return "", err
-		}
-		return u, nil
+func intValue(v any) (int, error) {
+	n, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("expected integer, got %s", toml.TypeName(v))
 	}
-	if strings.HasPrefix(v, "'") {
-		if len(v) < 2 || !strings.HasSuffix(v, "'") {
-			return "", fmt.Errorf("unterminated single-quoted string")
-		}
-		return v[1 : len(v)-1], nil
+	if int64(int(n)) != n {
+		return 0, fmt.Errorf("integer %d is out of range", n)
 	}
-
-	return v, nil
+	return int(n), nil
 }
 
-func parseTOMLListValue(v string) ([]string, error) {
-	v = strings.TrimSpace(v)
-	if v == "" {
-		return nil, nil
-	}
-	if strings.HasPrefix(v, "[") {
-		return parseTOMLStringArray(v)
+func durationValue(v any) (time.Duration, error) {
+	s, err := stringValue(v)
+	if err != nil {
+		return 0, err
 	}
-	s, err := parseTOMLStringValue(v)
+	d, err := time.ParseDuration(s)
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", s)
 	}
-	return splitList(s), nil
+	return d, nil
 }
 
-func parseTOMLStringArray(v string) ([]string, error) {
-	v = strings.TrimSpace(v)
-	if !strings.HasPrefix(v, "[") || !strings.HasSuffix(v, "]") {
-		return nil, fmt.Errorf("expected array syntax [..]")
+func formatDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
 	}
-	inner := strings.TrimSpace(v[1 : len(v)-1])
-	if inner == "" {
-		return nil, nil
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
 	}
+	return s
+}
 
-	items, err := splitArrayItems(inner)
-	if err != nil {
-		return nil, err
-	}
-	out := make([]string, 0, len(items))
-	for _, item := range items {
-		s, err := parseTOMLStringValue(item)
-		if err != nil {
-			return nil, err
-		}
-		if s != "" {
-			out = append(out, s)
+// listValue accepts an array of strings or a single string of
+// space- or comma-separated items. Items split from a string all get the
+// span of the string.
+func listValue(v any, span toml.Span) ([]string, []toml.Span, error) {
+	if s, ok := v.(string); ok {
+		items := splitList(s)
+		spans := make([]toml.Span, len(items))
+		for i := range spans {
+			spans[i] = span
 		}
+		return items, spans, nil
 	}
-	return out, nil
+	return stringArrayValue(v)
 }
 
-func splitArrayItems(inner string) ([]string, error) {
-	items := make([]string, 0, 4)
-	start := 0
-	inSingle := false
-	inDouble := false
-	escaped := false
-
-	for i := 0; i < len(inner); i++ {
-		c := inner[i]
-		if inDouble {
-			if escaped {
-				escaped = false
-				continue
-			}
-			if c == '\\' {
-				escaped = true
-				continue
-			}
-			if c == '"' {
-				inDouble = false
-			}
-			continue
-		}
-		if inSingle {
-			if c == '\'' {
-				inSingle = false
-			}
-			continue
-		}
+// itemError is a bad array item, reported at the item rather than at the
+// whole array.
+type itemError struct {
+	index int
+	span  toml.Span
+	err   error
+}
 
-		switch c {
-		case '"':
-			inDouble = true
-		case '\'':
-			inSingle = true
-		case ',':
-			part := strings.TrimSpace(inner[start:i])
-			if part == "" {
-				return nil, fmt.Errorf("empty array item")
-			}
-			items = append(items, part)
-			start = i + 1
-		}
-	}
+func (e *itemError) Error() string {
+	return fmt.Sprintf("item %d: %v", e.index+1, e.err)
+}
 
-	if inSingle || inDouble {
-		return nil, fmt.Errorf("unterminated quoted string in array")
+func stringArrayValue(v any) ([]string, []toml.Span, error) {
+	arr, ok := v.(*toml.Array)
+	if !ok {
+		return nil, nil, fmt.Errorf("expected array, got %s", toml.TypeName(v))
 	}
-	part := strings.TrimSpace(inner[start:])
-	if part == "" {
-		if strings.HasSuffix(strings.TrimSpace(inner), ",") {
-			return items, nil
+	out := make([]string, 0, len(arr.Items))
+	spans := make([]toml.Span, 0, len(arr.Items))
+	for i, item := range arr.Items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, nil, &itemError{index: i, span: arr.Spans[i], err: fmt.Errorf("expected string, got %s", toml.TypeName(item))}
+		}
+		if s != "" {
+			out = append(out, s)
+			spans = append(spans, arr.Spans[i])
 		}
-		return nil, fmt.Errorf("empty array item")
 	}
-	items = append(items, part)
-	return items, nil
+	return out, spans, nil
 }
 
-func bracketDelta(s string) int {
-	delta := 0
-	inSingle := false
-	inDouble := false
-	escaped := false
-
-	for i := 0; i < len(s); i++ {
-		c := s[i]
-		if inDouble {
-			if escaped {
-				escaped = false
-				continue
-			}
-			if c == '\\' {
-				escaped = true
-				continue
-			}
-			if c == '"' {
-				inDouble = false
-			}
-			continue
-		}
-		if inSingle {
-			if c == '\'' {
-				inSingle = false
-			}
-			continue
-		}
+func quoteTOML(v string) string {
+	return toml.Quote(v)
+}
 
-		switch c {
-		case '"':
-			inDouble = true
-		case '\'':
-			inSingle = true
-		case '[':
-			delta++
-		case ']':
-			delta--
-		}
+// formatKey quotes keys that TOML does not allow bare, such as names with
+// non-ASCII letters.
+func formatKey(k string) string {
+	if toml.IsBareKey(k) {
+		return k
 	}
-	return delta
+	return quoteTOML(k)
 }
 
-func quoteTOML(v string) string {
-	return strconv.Quote(v)
+// formatTaskKey writes a task name as the dotted key it was parsed from.
+func formatTaskKey(name string) string {
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		parts[i] = formatKey(part)
+	}
+	return strings.Join(parts, ".")
 }
 
 func formatTOMLArray(items []string) string {
@@ -820,14 +1253,6 @@ func formatTOMLArray(items []string) string {
 	return "[" + strings.Join(parts, ", ") + "]"
 }
 
-func splitKV(line string) (string, string, bool) {
-	parts := strings.SplitN(line, "=", 2)
-	if len(parts) != 2 {
-		return "", "", false
-	}
-	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
-}
-
 func splitList(v string) []string {
 	v = strings.TrimSpace(v)
 	v = strings.TrimPrefix(v, "[")
@@ -886,7 +1311,7 @@ func isTaskName(name string) bool {
 	return true
 }
 
-func resolveVars(raw map[string]string) (map[string]string, error) {
+func resolveVars(raw map[string]string, lookupEnv func(string) (string, bool)) (map[string]string, error) {
 	resolved := make(map[string]string, len(raw))
 	visit := make(map[string]int, len(raw))
 	stack := make([]string, 0, 8)
@@ -914,7 +1339,15 @@ func resolveVars(raw map[string]string) (map[string]string, error) {
 		stack = append(stack, name)
 		out, err := expandStrict(rawVal, name)
 		if err != nil {
-			return "", fmt.Errorf("var %q: %w", name, err)
+			// Leave the var resolved to "" so the vars that use it do not
+			// report the same failure again.
+			stack = stack[:len(stack)-1]
+			visit[name] = 2
+			var ve *varError
+			if errors.As(err, &ve) {
+				return "", err
+			}
+			return "", &varError{name: name, err: err}
 		}
 		stack = stack[:len(stack)-1]
 		visit[name] = 2
@@ -929,7 +1362,7 @@ func resolveVars(raw map[string]string) (map[string]string, error) {
 				return "", false, nil
 			}
 			if refName == current {
-				if envVal, ok := os.LookupEnv(refName); ok {
+				if envVal, ok := lookupEnv(refName); ok {
 					return envVal, true, nil
 				}
 				if hasFallback {
@@ -948,7 +1381,7 @@ func resolveVars(raw map[string]string) (map[string]string, error) {
 				}
 				return v, true, nil
 			}
-			if envVal, ok := os.LookupEnv(refName); ok {
+			if envVal, ok := lookupEnv(refName); ok {
 				return envVal, true, nil
 			}
 			if hasFallback {
@@ -962,18 +1395,47 @@ func resolveVars(raw map[string]string) (map[string]string, error) {
 		})
 	}
 
+	names := make([]string, 0, len(raw))
 	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
 		if _, err := resolveOne(name); err != nil {
-			return nil, err
+			errs = append(errs, err)
 		}
 	}
-	return resolved, nil
+	return resolved, errors.Join(errs...)
+}
+
+// varError is a failure to resolve the var name, reported for the var the
+// failing expression was written in.
+type varError struct {
+	name string
+	err  error
+}
+
+func (e *varError) Error() string {
+	return fmt.Sprintf("var %q: %v", e.name, e.err)
+}
+
+func (e *varError) Unwrap() error {
+	return e.err
+}
+
+type unresolvedError struct {
+	token string
+}
+
+func (e *unresolvedError) Error() string {
+	return fmt.Sprintf("unable to resolve %q", e.token)
 }
 
-func expandListLoose(values []string, vars map[string]string) []string {
+func expandListLoose(values []string, vars map[string]string, lookupEnv func(string) (string, bool)) []string {
 	out := make([]string, 0, len(values))
 	for _, v := range values {
-		exp := strings.TrimSpace(expandStringLoose(v, vars))
+		exp := strings.TrimSpace(expandStringLoose(v, vars, lookupEnv))
 		if exp != "" {
 			out = append(out, exp)
 		}
@@ -981,7 +1443,7 @@ func expandListLoose(values []string, vars map[string]string) []string {
 	return out
 }
 
-func expandStringLoose(input string, vars map[string]string) string {
+func expandStringLoose(input string, vars map[string]string, lookupEnv func(string) (string, bool)) string {
 	out, _ := expandTemplate(input, false, func(expr string) (string, bool, error) {
 		name, fallback, hasFallback := parseVarExpr(expr)
 		if !isVarName(name) {
@@ -990,11 +1452,11 @@ func expandStringLoose(input string, vars map[string]string) string {
 		if val, ok := vars[name]; ok {
 			return val, true, nil
 		}
-		if envVal, ok := os.LookupEnv(name); ok {
+		if envVal, ok := lookupEnv(name); ok {
 			return envVal, true, nil
 		}
 		if hasFallback {
-			return expandStringLoose(fallback, vars), true, nil
+			return expandStringLoose(fallback, vars, lookupEnv), true, nil
 		}
 		return "", false, nil
 	})
@@ -1040,7 +1502,7 @@ func expandTemplate(input string, strict bool, resolver func(expr string) (strin
 			} else {
 				token := input[i : end+1]
 				if strict {
-					return "", fmt.Errorf("unable to resolve %q", token)
+					return "", &unresolvedError{token: token}
 				}
 				b.WriteString(token)
 			}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestParseTOMLBasic(t *testing.T) {
@@ -209,3 +210,238 @@ func TestWriteStarterKeepsExistingRemfileAndOverwritesDocs(t *testing.T) {
 		t.Fatalf("REM.sr-Cyrl.md was not overwritten with starter docs")
 	}
 }
+
+func TestReferencedVarsFollowsVarDefinitions(t *testing.T) {
+	content := `
+default = "build"
+
+[vars]
+VERSION = "dev"
+LDFLAGS = "-X main.version=${VERSION}"
+UNUSED = "x"
+
+[task.build]
+cmds = ["go build -ldflags \"${LDFLAGS}\" ${EXTRA:-fast}"]
+`
+	rf, err := Parse(bytes.NewBufferString(strings.TrimSpace(content)))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	got := strings.Join(rf.ReferencedVars(rf.Tasks["build"].Cmds...), ",")
+	if got != "EXTRA,LDFLAGS,VERSION" {
+		t.Fatalf("ReferencedVars() = %q", got)
+	}
+}
+
+func TestParseCacheSection(t *testing.T) {
+	content := `
+default = "build"
+
+[cache]
+max_size = "2GB"
+remote = "https://cache.example.com/rem"
+read_only = true
+
+[task.build]
+cmds = ["go build ./..."]
+`
+	rf, err := Parse(bytes.NewBufferString(strings.TrimSpace(content)))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	want := CacheSettings{MaxSize: "2GB", Remote: "https://cache.example.com/rem", ReadOnly: true}
+	if rf.Cache != want {
+		t.Fatalf("cache = %#v, want %#v", rf.Cache, want)
+	}
+
+	rf2, err := Parse(bytes.NewBufferString(Format(rf)))
+	if err != nil {
+		t.Fatalf("Parse(formatted) error: %v", err)
+	}
+	if rf2.Cache != want {
+		t.Fatalf("formatted cache = %#v, want %#v", rf2.Cache, want)
+	}
+}
+
+func TestParseTimeoutAndRetries(t *testing.T) {
+	content := `
+default = "it"
+
+[task.it]
+timeout = "5m"
+retries = 2
+backoff = "1s"
+cmds = ["go test -tags integration ./..."]
+`
+	rf, err := Parse(bytes.NewBufferString(strings.TrimSpace(content)))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	task := rf.Tasks["it"]
+	if task.Timeout != 5*time.Minute || task.Retries != 2 || task.Backoff != time.Second {
+		t.Fatalf("timeout=%v retries=%d backoff=%v", task.Timeout, task.Retries, task.Backoff)
+	}
+
+	formatted := Format(rf)
+	if !strings.Contains(formatted, "timeout = \"5m\"") || !strings.Contains(formatted, "retries = 2") {
+		t.Fatalf("formatted output lost timeout/retries:\n%s", formatted)
+	}
+
+	if _, err := Parse(bytes.NewBufferString("[task.x]\nretries = \"many\"")); err == nil {
+		t.Fatalf("expected error for non-integer retries")
+	}
+}
+
+func TestParseVerifyMode(t *testing.T) {
+	rf, err := Parse(bytes.NewBufferString("[task.docs]\nverify = \"warn\"\noutputs = [\"site/\"]\ncmds = [\"make docs\"]"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got := rf.Tasks["docs"].Verify; got != VerifyWarn {
+		t.Fatalf("verify = %q, want %q", got, VerifyWarn)
+	}
+	if !strings.Contains(Format(rf), "verify = \"warn\"") {
+		t.Fatalf("formatted output lost verify:\n%s", Format(rf))
+	}
+
+	if _, err := Parse(bytes.NewBufferString("[task.x]\nverify = \"sometimes\"")); err == nil {
+		t.Fatalf("expected error for unknown verify mode")
+	}
+}
+
+func TestParseRestart(t *testing.T) {
+	rf, err := Parse(bytes.NewBufferString("[task.serve]\nrestart = true\ncmds = [\"go run ./cmd/server\"]"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if !rf.Tasks["serve"].Restart {
+		t.Fatalf("restart was not parsed")
+	}
+	if !strings.Contains(Format(rf), "restart = true") {
+		t.Fatalf("formatted output lost restart:\n%s", Format(rf))
+	}
+}
+
+func TestParseDepfile(t *testing.T) {
+	rf, err := Parse(bytes.NewBufferString("[task.obj]\noutputs = [\"build/foo.o\"]\ndepfile = \"build/foo.d\"\ncmds = [\"cc -MD -c foo.c -o build/foo.o\"]"))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got := rf.Tasks["obj"].Depfile; got != "build/foo.d" {
+		t.Fatalf("depfile = %q", got)
+	}
+	if !strings.Contains(Format(rf), "depfile = \"build/foo.d\"") {
+		t.Fatalf("formatted output lost depfile:\n%s", Format(rf))
+	}
+}
+
+func TestParseEnv(t *testing.T) {
+	content := `
+default = "build-linux"
+clean_env = true
+keep_env = ["GOPATH", "GO*"]
+
+[env]
+CGO_ENABLED = "0"
+
+[task.build-linux]
+env = { GOOS = "linux", GOARCH = "amd64" }
+cmds = ["go build ./..."]
+`
+	rf, err := Parse(bytes.NewBufferString(strings.TrimSpace(content)))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if !rf.CleanEnv || len(rf.KeepEnv) != 2 || len(rf.Env) != 1 {
+		t.Fatalf("clean_env=%v keep_env=%v env=%v", rf.CleanEnv, rf.KeepEnv, rf.Env)
+	}
+	env := rf.TaskEnv(rf.Tasks["build-linux"])
+	if len(env) != 3 || env[1] != (EnvVar{Name: "GOOS", Value: "linux"}) {
+		t.Fatalf("TaskEnv() = %v", env)
+	}
+
+	formatted := Format(rf)
+	for _, want := range []string{"clean_env = true", "[env]\nCGO_ENABLED = \"0\"", `env = { GOOS = "linux", GOARCH = "amd64" }`} {
+		if !strings.Contains(formatted, want) {
+			t.Fatalf("formatted output missing %q:\n%s", want, formatted)
+		}
+	}
+}
+
+func TestParseFullTOML(t *testing.T) {
+	content := `
+default = 'build'
+
+[vars]
+JOBS = 4
+GREETING = """
+hello \
+  world"""
+
+[task.build]
+desc = "Build\tall"
+cmds = [
+  '''go build -ldflags "-X main.v=1" ./...''', # literal, no escapes
+  "echo é",
+]
+env.CGO_ENABLED = 0
+params = { pkg = { default = "./...", desc = "packages" } }
+
+[task.docs.site]
+cmds = ["make site"]
+
+[task."docs.api"]
+deps = ["docs.site"]
+`
+	rf, err := Parse(bytes.NewBufferString(strings.TrimSpace(content)))
+	if err != nil {
+		t.Fatalf("Parse() error: %v", err)
+	}
+	if got := strings.Join(rf.Order, " "); got != "build docs.site docs.api" {
+		t.Fatalf("order = %q", got)
+	}
+	if rf.Vars["JOBS"] != "4" || rf.Vars["GREETING"] != "hello world" {
+		t.Fatalf("vars = %v", rf.Vars)
+	}
+	build := rf.Tasks["build"]
+	if build.Desc != "Build\tall" || len(build.Cmds) != 2 || build.Cmds[0] != `go build -ldflags "-X main.v=1" ./...` || build.Cmds[1] != "echo é" {
+		t.Fatalf("build = %#v", build)
+	}
+	if len(build.Env) != 1 || build.Env[0] != (EnvVar{Name: "CGO_ENABLED", Value: "0"}) {
+		t.Fatalf("env = %v", build.Env)
+	}
+	if len(build.Params) != 1 || build.Params[0].Desc != "packages" {
+		t.Fatalf("params = %v", build.Params)
+	}
+	if _, ok := rf.Tasks["docs"]; ok {
+		t.Fatalf("implicit table docs should not be a task")
+	}
+
+	rf2, err := Parse(bytes.NewBufferString(Format(rf)))
+	if err != nil {
+		t.Fatalf("Parse(formatted) error: %v\n%s", err, Format(rf))
+	}
+	if got := strings.Join(rf2.Order, " "); got != "build docs.site docs.api" {
+		t.Fatalf("order after format = %q", got)
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"[task.a]\ncmds = [\"x\"]\n[task.a]", "Remfile:3:7: table task.a is already defined"},
+		{"[task.a]\ncmds = [\"x\"]\n[task.\"a.b\"]\n[task.a.b]", "Remfile:4:9: duplicate task section \"a.b\""},
+		{"[[task.a]]\ncmds = [\"x\"]", "Remfile:1:1: task \"a\": expected table, got array"},
+		{"[task.a]\ncmds = [1]", "Remfile:2:9: task \"a\" cmds: item 1: expected string, got integer"},
+		{"[task.a]\nrestart = \"yes\"", "Remfile:2:11: task \"a\" restart: expected true or false, got string"},
+		{"[task.a]\ncmd = \"x\"\n[extra]", "Remfile:3:2: unsupported section \"extra\""},
+	}
+	for _, tt := range tests {
+		_, err := Parse(bytes.NewBufferString(tt.content))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Parse(%q) error = %v, want %q", tt.content, err, tt.want)
+		}
+	}
+}
@@ -73,3 +73,23 @@ func parentShell() string {
 	}
 	return name
 }
+
+// QuoteArgs joins args into one string that the task shell splits back
+// into the same words.
+func QuoteArgs(args []string) string {
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		parts = append(parts, quoteArg(arg))
+	}
+	return strings.Join(parts, " ")
+}
+
+func quoteArg(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\r\n\"'\\$`&|;<>()*?[]{}~#!%^") {
+		return arg
+	}
+	if runtime.GOOS == "windows" {
+		return `"` + strings.ReplaceAll(arg, `"`, `\"`) + `"`
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
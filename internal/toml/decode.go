@@ -0,0 +1,923 @@
+package toml
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Error is a syntax or structure error at a position in the document.
+type Error struct {
+	Pos Position
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Pos.Line, e.Msg)
+}
+
+type parser struct {
+	src  string
+	off  int
+	line int
+	col  int
+	root *Table
+	cur  *Table
+}
+
+// Decode parses a TOML 1.0 document.
+func Decode(data []byte) (*Table, error) {
+	p := &parser{src: string(data), line: 1, col: 1}
+	if err := p.checkUTF8(); err != nil {
+		return nil, err
+	}
+	p.src = strings.TrimPrefix(p.src, "\uFEFF")
+	p.root = newTable(p.pos(), kindHeader)
+	p.cur = p.root
+
+	for {
+		p.skipWS()
+		if p.eof() {
+			return p.root, nil
+		}
+		switch p.peek() {
+		case '#', '\n', '\r':
+		case '[':
+			if err := p.header(); err != nil {
+				return nil, err
+			}
+		default:
+			if err := p.keyval(p.cur); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) checkUTF8() error {
+	for i := 0; i < len(p.src); {
+		r, size := utf8.DecodeRuneInString(p.src[i:])
+		if r == utf8.RuneError && size == 1 {
+			return p.errorf(p.pos(), "invalid UTF-8")
+		}
+		p.advance(size)
+		i += size
+	}
+	p.off, p.line, p.col = 0, 1, 1
+	return nil
+}
+
+func (p *parser) pos() Position {
+	return Position{Line: p.line, Col: p.col}
+}
+
+func (p *parser) errorf(pos Position, format string, args ...any) error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool {
+	return p.off >= len(p.src)
+}
+
+func (p *parser) peek() byte {
+	if p.off >= len(p.src) {
+		return 0
+	}
+	return p.src[p.off]
+}
+
+func (p *parser) rest() string {
+	return p.src[p.off:]
+}
+
+func (p *parser) advance(n int) {
+	for i := 0; i < n && p.off < len(p.src); i++ {
+		c := p.src[p.off]
+		p.off++
+		switch {
+		case c == '\n':
+			p.line++
+			p.col = 1
+		case c&0xC0 != 0x80:
+			p.col++
+		}
+	}
+}
+
+func (p *parser) skipWS() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.advance(1)
+	}
+}
+
+// skipBlank skips whitespace, comments and newlines, as allowed between
+// array items.
+func (p *parser) skipBlank() error {
+	for {
+		p.skipWS()
+		switch p.peek() {
+		case '#':
+			if err := p.comment(); err != nil {
+				return err
+			}
+		case '\n', '\r':
+			if err := p.newline(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) newline() error {
+	switch {
+	case p.peek() == '\n':
+		p.advance(1)
+	case strings.HasPrefix(p.rest(), "\r\n"):
+		p.advance(2)
+	default:
+		return p.errorf(p.pos(), "bare carriage return")
+	}
+	return nil
+}
+
+func (p *parser) comment() error {
+	p.advance(1)
+	for !p.eof() {
+		c := p.peek()
+		if c == '\n' || strings.HasPrefix(p.rest(), "\r\n") {
+			return nil
+		}
+		if isControl(c) {
+			return p.errorf(p.pos(), "control character %U in comment", rune(c))
+		}
+		p.advance(1)
+	}
+	return nil
+}
+
+func (p *parser) endOfLine() error {
+	p.skipWS()
+	if p.peek() == '#' {
+		if err := p.comment(); err != nil {
+			return err
+		}
+	}
+	if p.eof() {
+		return nil
+	}
+	if c := p.peek(); c != '\n' && c != '\r' {
+		return p.errorf(p.pos(), "expected newline, got %s", p.describeNext())
+	}
+	return p.newline()
+}
+
+func (p *parser) describeNext() string {
+	if p.eof() {
+		return "end of file"
+	}
+	r, _ := utf8.DecodeRuneInString(p.rest())
+	return strconv.QuoteRune(r)
+}
+
+func isControl(c byte) bool {
+	return (c < 0x20 && c != '\t') || c == 0x7f
+}
+
+// key parses a possibly dotted key and the span of each part.
+func (p *parser) key() ([]string, []Span, error) {
+	var (
+		parts []string
+		spans []Span
+	)
+	for {
+		p.skipWS()
+		pos := p.pos()
+		var part string
+		switch c := p.peek(); {
+		case c == '"':
+			if strings.HasPrefix(p.rest(), `"""`) {
+				return nil, nil, p.errorf(pos, "multi-line strings cannot be keys")
+			}
+			s, err := p.basicString()
+			if err != nil {
+				return nil, nil, err
+			}
+			part = s
+		case c == '\'':
+			if strings.HasPrefix(p.rest(), "'''") {
+				return nil, nil, p.errorf(pos, "multi-line strings cannot be keys")
+			}
+			s, err := p.literalString()
+			if err != nil {
+				return nil, nil, err
+			}
+			part = s
+		case isBareKeyChar(c):
+			start := p.off
+			for isBareKeyChar(p.peek()) {
+				p.advance(1)
+			}
+			part = p.src[start:p.off]
+		default:
+			return nil, nil, p.errorf(pos, "expected key, got %s", p.describeNext())
+		}
+		parts = append(parts, part)
+		spans = append(spans, Span{Start: pos, End: p.pos()})
+
+		p.skipWS()
+		if p.peek() != '.' {
+			return parts, spans, nil
+		}
+		p.advance(1)
+	}
+}
+
+func joinKey(parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		if IsBareKey(part) {
+			quoted[i] = part
+		} else {
+			quoted[i] = Quote(part)
+		}
+	}
+	return strings.Join(quoted, ".")
+}
+
+// keyval parses `key = value` into t. Dotted keys create or extend tables
+// that were themselves created by dotted keys.
+func (p *parser) keyval(t *Table) error {
+	parts, spans, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.peek() != '=' {
+		return p.errorf(p.pos(), "expected '=' after key %s, got %s", joinKey(parts), p.describeNext())
+	}
+	p.advance(1)
+	p.skipWS()
+	start := p.pos()
+	val, err := p.value()
+	if err != nil {
+		return err
+	}
+	valSpan := Span{Start: start, End: p.pos()}
+
+	for i, part := range parts[:len(parts)-1] {
+		v, ok := t.values[part]
+		if !ok {
+			sub := newTable(spans[i].Start, kindDotted)
+			t.set(part, sub, spans[i], spans[i])
+			t = sub
+			continue
+		}
+		sub, isTable := v.(*Table)
+		if !isTable || sub.kind != kindDotted {
+			return p.errorf(spans[i].Start, "cannot add keys to %s %s", TypeName(v), joinKey(parts[:i+1]))
+		}
+		t = sub
+	}
+	last := len(parts) - 1
+	if _, exists := t.values[parts[last]]; exists {
+		return p.errorf(spans[last].Start, "duplicate key %s", joinKey(parts))
+	}
+	t.set(parts[last], val, spans[last], valSpan)
+	return nil
+}
+
+// header parses [table] and [[array.of.tables]] and makes the table they
+// name current.
+func (p *parser) header() error {
+	pos := p.pos()
+	p.advance(1)
+	array := false
+	if p.peek() == '[' {
+		array = true
+		p.advance(1)
+	}
+	parts, spans, err := p.key()
+	if err != nil {
+		return err
+	}
+	if p.peek() != ']' {
+		return p.errorf(p.pos(), "expected ']' after table name, got %s", p.describeNext())
+	}
+	p.advance(1)
+	if array {
+		if p.peek() != ']' {
+			return p.errorf(p.pos(), "expected ']]' after array of tables name, got %s", p.describeNext())
+		}
+		p.advance(1)
+	}
+	hdr := Span{Start: pos, End: p.pos()}
+
+	t := p.root
+	for i, part := range parts[:len(parts)-1] {
+		v, ok := t.values[part]
+		if !ok {
+			sub := newTable(spans[i].Start, kindImplicit)
+			t.set(part, sub, spans[i], spans[i])
+			t = sub
+			continue
+		}
+		switch v := v.(type) {
+		case *Table:
+			if v.kind == kindInline {
+				return p.errorf(spans[i].Start, "cannot extend inline table %s", joinKey(parts[:i+1]))
+			}
+			t = v
+		case *Array:
+			if !v.tables {
+				return p.errorf(spans[i].Start, "cannot extend static array %s", joinKey(parts[:i+1]))
+			}
+			t = v.Items[len(v.Items)-1].(*Table)
+		default:
+			return p.errorf(spans[i].Start, "key %s is already defined as %s", joinKey(parts[:i+1]), TypeName(v))
+		}
+	}
+
+	last := len(parts) - 1
+	v, exists := t.values[parts[last]]
+	if array {
+		arr, ok := v.(*Array)
+		switch {
+		case !exists:
+			arr = &Array{tables: true}
+			t.set(parts[last], arr, spans[last], hdr)
+		case !ok || !arr.tables:
+			return p.errorf(spans[last].Start, "key %s is already defined as %s", joinKey(parts), TypeName(v))
+		}
+		p.cur = newTable(pos, kindHeader)
+		arr.append(p.cur, hdr)
+		return nil
+	}
+	if !exists {
+		p.cur = newTable(pos, kindHeader)
+		t.set(parts[last], p.cur, spans[last], hdr)
+		return nil
+	}
+	if sub, ok := v.(*Table); ok && sub.kind == kindImplicit {
+		sub.kind = kindHeader
+		sub.Pos = pos
+		t.keySpan[parts[last]] = spans[last]
+		t.valSpan[parts[last]] = hdr
+		p.cur = sub
+		return nil
+	}
+	if _, ok := v.(*Table); ok {
+		return p.errorf(spans[last].Start, "table %s is already defined", joinKey(parts))
+	}
+	return p.errorf(spans[last].Start, "key %s is already defined as %s", joinKey(parts), TypeName(v))
+}
+
+func (p *parser) value() (any, error) {
+	pos := p.pos()
+	rest := p.rest()
+	switch c := p.peek(); {
+	case strings.HasPrefix(rest, `"""`):
+		return p.multilineString('"')
+	case c == '"':
+		return p.basicString()
+	case strings.HasPrefix(rest, "'''"):
+		return p.multilineString('\'')
+	case c == '\'':
+		return p.literalString()
+	case strings.HasPrefix(rest, "true"):
+		p.advance(4)
+		return true, nil
+	case strings.HasPrefix(rest, "false"):
+		p.advance(5)
+		return false, nil
+	case c == '[':
+		return p.array()
+	case c == '{':
+		return p.inlineTable()
+	case c == '+' || c == '-' || c == 'i' || c == 'n' || (c >= '0' && c <= '9'):
+		return p.scalar()
+	case p.eof() || c == '\n' || c == '\r' || c == '#':
+		return nil, p.errorf(pos, "expected value")
+	}
+	return nil, p.errorf(pos, "expected value, got %s", p.describeNext())
+}
+
+func (p *parser) basicString() (string, error) {
+	pos := p.pos()
+	p.advance(1)
+	var b strings.Builder
+	for {
+		c := p.peek()
+		switch {
+		case p.eof() || c == '\n' || c == '\r':
+			return "", p.errorf(pos, "unterminated string")
+		case c == '"':
+			p.advance(1)
+			return b.String(), nil
+		case c == '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case isControl(c):
+			return "", p.errorf(p.pos(), "control character %U in string", rune(c))
+		default:
+			b.WriteByte(c)
+			p.advance(1)
+		}
+	}
+}
+
+func (p *parser) escape(b *strings.Builder) error {
+	pos := p.pos()
+	p.advance(1)
+	c := p.peek()
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"':
+		b.WriteByte('"')
+	case '\\':
+		b.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		hex := p.rest()[1:]
+		if len(hex) < n {
+			return p.errorf(pos, "invalid unicode escape")
+		}
+		hex = hex[:n]
+		for i := 0; i < n; i++ {
+			if !isHexDigit(hex[i]) {
+				return p.errorf(pos, "invalid unicode escape \\%c%s", c, hex)
+			}
+		}
+		code, _ := strconv.ParseUint(hex, 16, 32)
+		if !utf8.ValidRune(rune(code)) {
+			return p.errorf(pos, "invalid unicode scalar value \\%c%s", c, hex)
+		}
+		b.WriteRune(rune(code))
+		p.advance(1 + n)
+		return nil
+	default:
+		return p.errorf(pos, "invalid escape sequence \\%s", strings.Trim(p.describeNext(), "'"))
+	}
+	p.advance(1)
+	return nil
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func (p *parser) literalString() (string, error) {
+	pos := p.pos()
+	p.advance(1)
+	start := p.off
+	for {
+		c := p.peek()
+		switch {
+		case p.eof() || c == '\n' || c == '\r':
+			return "", p.errorf(pos, "unterminated string")
+		case c == '\'':
+			s := p.src[start:p.off]
+			p.advance(1)
+			return s, nil
+		case isControl(c):
+			return "", p.errorf(p.pos(), "control character %U in string", rune(c))
+		}
+		p.advance(1)
+	}
+}
+
+// multilineString parses multi-line basic and literal strings. A newline
+// right after the opening delimiter is trimmed, and up to two quotes may
+// directly precede the closing delimiter.
+func (p *parser) multilineString(quote byte) (string, error) {
+	pos := p.pos()
+	p.advance(3)
+	if p.peek() == '\n' {
+		p.advance(1)
+	} else if strings.HasPrefix(p.rest(), "\r\n") {
+		p.advance(2)
+	}
+
+	var b strings.Builder
+	for {
+		c := p.peek()
+		switch {
+		case p.eof():
+			return "", p.errorf(pos, "unterminated multi-line string")
+		case c == quote:
+			n := 0
+			for n < len(p.rest()) && p.rest()[n] == quote {
+				n++
+			}
+			if n < 3 {
+				b.WriteString(p.rest()[:n])
+				p.advance(n)
+				continue
+			}
+			if n > 5 {
+				return "", p.errorf(p.pos(), "too many quotes at end of multi-line string")
+			}
+			b.WriteString(p.rest()[:n-3])
+			p.advance(n)
+			return b.String(), nil
+		case c == '\\' && quote == '"':
+			if p.lineEndingBackslash() {
+				continue
+			}
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		case c == '\n' || c == '\r':
+			if err := p.newline(); err != nil {
+				return "", err
+			}
+			b.WriteByte('\n')
+		case isControl(c):
+			return "", p.errorf(p.pos(), "control character %U in string", rune(c))
+		default:
+			b.WriteByte(c)
+			p.advance(1)
+		}
+	}
+}
+
+// lineEndingBackslash skips a `\` that ends a line together with all the
+// whitespace and newlines after it.
+func (p *parser) lineEndingBackslash() bool {
+	rest := p.rest()[1:]
+	trimmed := strings.TrimLeft(rest, " \t")
+	if !strings.HasPrefix(trimmed, "\n") && !strings.HasPrefix(trimmed, "\r\n") {
+		return false
+	}
+	p.advance(1)
+	for {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\n':
+			p.advance(1)
+		case strings.HasPrefix(p.rest(), "\r\n"):
+			p.advance(2)
+		default:
+			return true
+		}
+	}
+}
+
+func (p *parser) array() (*Array, error) {
+	pos := p.pos()
+	p.advance(1)
+	arr := &Array{}
+	for {
+		if err := p.skipBlank(); err != nil {
+			return nil, err
+		}
+		if p.peek() == ']' {
+			p.advance(1)
+			return arr, nil
+		}
+		itemPos := p.pos()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr.append(v, Span{Start: itemPos, End: p.pos()})
+		if err := p.skipBlank(); err != nil {
+			return nil, err
+		}
+		switch p.peek() {
+		case ',':
+			p.advance(1)
+		case ']':
+			p.advance(1)
+			return arr, nil
+		default:
+			if p.eof() {
+				return nil, p.errorf(pos, "unterminated array")
+			}
+			return nil, p.errorf(p.pos(), "expected ',' or ']' in array, got %s", p.describeNext())
+		}
+	}
+}
+
+func (p *parser) inlineTable() (*Table, error) {
+	t := newTable(p.pos(), kindDotted)
+	p.advance(1)
+	p.skipWS()
+	if p.peek() == '}' {
+		p.advance(1)
+		freeze(t)
+		return t, nil
+	}
+	for {
+		if err := p.keyval(t); err != nil {
+			return nil, err
+		}
+		p.skipWS()
+		switch p.peek() {
+		case ',':
+			p.advance(1)
+			p.skipWS()
+			if p.peek() == '}' {
+				return nil, p.errorf(p.pos(), "trailing comma in inline table")
+			}
+		case '}':
+			p.advance(1)
+			freeze(t)
+			return t, nil
+		default:
+			return nil, p.errorf(p.pos(), "expected ',' or '}' in inline table, got %s", p.describeNext())
+		}
+	}
+}
+
+// freeze marks an inline table and the tables its dotted keys created as
+// closed to later headers and dotted keys.
+func freeze(t *Table) {
+	t.kind = kindInline
+	for _, v := range t.values {
+		if sub, ok := v.(*Table); ok && sub.kind == kindDotted {
+			freeze(sub)
+		}
+	}
+}
+
+// scalar parses numbers, inf/nan and dates and times.
+func (p *parser) scalar() (any, error) {
+	pos := p.pos()
+	start := p.off
+	for isScalarChar(p.peek()) {
+		p.advance(1)
+	}
+	tok := p.src[start:p.off]
+	// A date and a time may be separated by a single space.
+	if isDate(tok) && len(p.rest()) > 3 && p.rest()[0] == ' ' && isDigit(p.rest()[1]) && isDigit(p.rest()[2]) && p.rest()[3] == ':' {
+		p.advance(1)
+		for isScalarChar(p.peek()) {
+			p.advance(1)
+		}
+		tok = p.src[start:p.off]
+	}
+
+	switch {
+	case len(tok) >= 10 && tok[4] == '-' && tok[7] == '-':
+		v, err := parseDateTime(tok)
+		if err != nil {
+			return nil, p.errorf(pos, "%v", err)
+		}
+		return v, nil
+	case len(tok) >= 3 && tok[2] == ':':
+		t, rest, err := parseTime(tok)
+		if err == nil && rest != "" {
+			err = fmt.Errorf("invalid time %q", tok)
+		}
+		if err != nil {
+			return nil, p.errorf(pos, "%v", err)
+		}
+		return t, nil
+	}
+	v, err := parseNumber(tok)
+	if err != nil {
+		return nil, p.errorf(pos, "%v", err)
+	}
+	return v, nil
+}
+
+func isScalarChar(c byte) bool {
+	return isBareKeyChar(c) || c == '+' || c == '.' || c == ':'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isDate(s string) bool {
+	if len(s) != 10 || s[4] != '-' || s[7] != '-' {
+		return false
+	}
+	for _, i := range []int{0, 1, 2, 3, 5, 6, 8, 9} {
+		if !isDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseNumber(tok string) (any, error) {
+	switch tok {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+
+	for prefix, base := range map[string]int{"0x": 16, "0o": 8, "0b": 2} {
+		if !strings.HasPrefix(tok, prefix) {
+			continue
+		}
+		digits, ok := stripUnderscores(tok[2:], func(c byte) bool {
+			switch base {
+			case 16:
+				return isHexDigit(c)
+			case 8:
+				return c >= '0' && c <= '7'
+			}
+			return c == '0' || c == '1'
+		})
+		if !ok {
+			return nil, fmt.Errorf("invalid integer %q", tok)
+		}
+		n, err := strconv.ParseInt(digits, base, 64)
+		if err != nil {
+			return nil, fmt.Errorf("integer %q is out of range", tok)
+		}
+		return n, nil
+	}
+
+	body := strings.TrimLeft(tok, "+-")
+	if len(tok)-len(body) > 1 {
+		return nil, fmt.Errorf("invalid number %q", tok)
+	}
+	sign := tok[:len(tok)-len(body)]
+
+	if !strings.ContainsAny(body, ".eE") {
+		digits, ok := decimalInt(body)
+		if !ok {
+			return nil, fmt.Errorf("invalid number %q", tok)
+		}
+		n, err := strconv.ParseInt(sign+digits, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("integer %q is out of range", tok)
+		}
+		return n, nil
+	}
+
+	mantissa, exp, hasExp := strings.Cut(strings.ToLower(body), "e")
+	intPart, frac, hasFrac := strings.Cut(mantissa, ".")
+	clean, ok := decimalInt(intPart)
+	if !ok {
+		return nil, fmt.Errorf("invalid float %q", tok)
+	}
+	if hasFrac {
+		digits, ok := stripUnderscores(frac, isDigit)
+		if !ok {
+			return nil, fmt.Errorf("invalid float %q", tok)
+		}
+		clean += "." + digits
+	}
+	if hasExp {
+		expSign := ""
+		if exp != "" && (exp[0] == '+' || exp[0] == '-') {
+			expSign, exp = exp[:1], exp[1:]
+		}
+		digits, ok := stripUnderscores(exp, isDigit)
+		if !ok {
+			return nil, fmt.Errorf("invalid float %q", tok)
+		}
+		clean += "e" + expSign + digits
+	}
+	f, err := strconv.ParseFloat(sign+clean, 64)
+	if err != nil {
+		return nil, fmt.Errorf("float %q is out of range", tok)
+	}
+	return f, nil
+}
+
+// decimalInt validates the digits of a decimal integer, which may not have
+// leading zeros.
+func decimalInt(s string) (string, bool) {
+	digits, ok := stripUnderscores(s, isDigit)
+	if !ok || (len(s) > 1 && s[0] == '0') {
+		return "", false
+	}
+	return digits, true
+}
+
+// stripUnderscores removes the underscores of a digit sequence; each must
+// sit between two digits.
+func stripUnderscores(s string, digit func(byte) bool) (string, bool) {
+	if s == "" || s[0] == '_' || s[len(s)-1] == '_' {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '_':
+			if s[i-1] == '_' {
+				return "", false
+			}
+		case digit(s[i]):
+			b.WriteByte(s[i])
+		default:
+			return "", false
+		}
+	}
+	return b.String(), true
+}
+
+func parseDateTime(tok string) (any, error) {
+	if !isDate(tok[:10]) {
+		return nil, fmt.Errorf("invalid date %q", tok)
+	}
+	year, _ := strconv.Atoi(tok[0:4])
+	month, _ := strconv.Atoi(tok[5:7])
+	day, _ := strconv.Atoi(tok[8:10])
+	if month < 1 || month > 12 || day < 1 || day > daysIn(time.Month(month), year) {
+		return nil, fmt.Errorf("invalid date %q", tok)
+	}
+	date := LocalDate{Year: year, Month: time.Month(month), Day: day}
+	if len(tok) == 10 {
+		return date, nil
+	}
+
+	if sep := tok[10]; sep != 'T' && sep != 't' && sep != ' ' {
+		return nil, fmt.Errorf("invalid datetime %q", tok)
+	}
+	clock, rest, err := parseTime(tok[11:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid datetime %q", tok)
+	}
+	if rest == "" {
+		return LocalDateTime{Date: date, Time: clock}, nil
+	}
+
+	var offset int
+	switch {
+	case rest == "Z" || rest == "z":
+	case len(rest) == 6 && (rest[0] == '+' || rest[0] == '-') && rest[3] == ':' &&
+		isDigit(rest[1]) && isDigit(rest[2]) && isDigit(rest[4]) && isDigit(rest[5]):
+		h, _ := strconv.Atoi(rest[1:3])
+		m, _ := strconv.Atoi(rest[4:6])
+		if h > 23 || m > 59 {
+			return nil, fmt.Errorf("invalid datetime %q", tok)
+		}
+		offset = h*3600 + m*60
+		if rest[0] == '-' {
+			offset = -offset
+		}
+	default:
+		return nil, fmt.Errorf("invalid datetime %q", tok)
+	}
+	zone := time.UTC
+	if offset != 0 || rest[0] == '+' || rest[0] == '-' {
+		zone = time.FixedZone("", offset)
+	}
+	return time.Date(year, time.Month(month), day, clock.Hour, clock.Minute, clock.Second, clock.Nanosecond, zone), nil
+}
+
+// parseTime parses hh:mm:ss with optional fractional seconds and returns
+// what follows.
+func parseTime(s string) (LocalTime, string, error) {
+	if len(s) < 8 || s[2] != ':' || s[5] != ':' {
+		return LocalTime{}, "", fmt.Errorf("invalid time %q", s)
+	}
+	for _, i := range []int{0, 1, 3, 4, 6, 7} {
+		if !isDigit(s[i]) {
+			return LocalTime{}, "", fmt.Errorf("invalid time %q", s)
+		}
+	}
+	var t LocalTime
+	t.Hour, _ = strconv.Atoi(s[0:2])
+	t.Minute, _ = strconv.Atoi(s[3:5])
+	t.Second, _ = strconv.Atoi(s[6:8])
+	if t.Hour > 23 || t.Minute > 59 || t.Second > 60 {
+		return LocalTime{}, "", fmt.Errorf("invalid time %q", s)
+	}
+	rest := s[8:]
+	if strings.HasPrefix(rest, ".") {
+		n := 1
+		for n < len(rest) && isDigit(rest[n]) {
+			n++
+		}
+		if n == 1 {
+			return LocalTime{}, "", fmt.Errorf("invalid time %q", s)
+		}
+		frac := (rest[1:n] + "000000000")[:9]
+		t.Nanosecond, _ = strconv.Atoi(frac)
+		rest = rest[n:]
+	}
+	return t, rest, nil
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
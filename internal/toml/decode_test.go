@@ -0,0 +1,209 @@
+package toml
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// plain converts a decoded table to maps and slices for comparison.
+func plain(v any) any {
+	switch v := v.(type) {
+	case *Table:
+		out := make(map[string]any, v.Len())
+		for _, k := range v.Keys() {
+			val, _ := v.Get(k)
+			out[k] = plain(val)
+		}
+		return out
+	case *Array:
+		out := make([]any, 0, len(v.Items))
+		for _, item := range v.Items {
+			out = append(out, plain(item))
+		}
+		return out
+	}
+	return v
+}
+
+func decode(t *testing.T, src string) map[string]any {
+	t.Helper()
+	doc, err := Decode([]byte(src))
+	if err != nil {
+		t.Fatalf("Decode(%q) error: %v", src, err)
+	}
+	return plain(doc).(map[string]any)
+}
+
+func TestDecodeValues(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]any
+	}{
+		{"basic string escapes", `s = "tab\there \"q\" \\ \u00e9 \U0001F600"`, map[string]any{"s": "tab\there \"q\" \\ é 😀"}},
+		{"literal string", `s = 'C:\Users\nodejs'`, map[string]any{"s": `C:\Users\nodejs`}},
+		{"multi-line basic", "s = \"\"\"\nRoses\r\nViolets\"\"\"", map[string]any{"s": "Roses\nViolets"}},
+		{"line ending backslash", "s = \"\"\"\\\n   The quick \\\n\n  fox\"\"\"", map[string]any{"s": "The quick fox"}},
+		{"quotes before closing", `s = """"one" ""two"""""`, map[string]any{"s": `"one" ""two""`}},
+		{"multi-line literal", "s = '''\nfirst\n  'second' \\n'''", map[string]any{"s": "first\n  'second' \\n"}},
+		{"integers", "a = +99\nb = -17\nc = 1_000\nd = 0xDEAD_beef\ne = 0o755\nf = 0b1101\ng = -0", map[string]any{"a": int64(99), "b": int64(-17), "c": int64(1000), "d": int64(0xdeadbeef), "e": int64(0o755), "f": int64(13), "g": int64(0)}},
+		{"floats", "a = 3.1415\nb = -0.01\nc = 5e+22\nd = 6.626e-34\ne = 224_617.445_991\nf = 1e06", map[string]any{"a": 3.1415, "b": -0.01, "c": 5e22, "d": 6.626e-34, "e": 224617.445991, "f": 1e6}},
+		{"booleans", "t = true\nf = false", map[string]any{"t": true, "f": false}},
+		{"offset datetime", "a = 1979-05-27T07:32:00Z\nb = 1979-05-27 00:32:00.999999-07:00", map[string]any{
+			"a": time.Date(1979, 5, 27, 7, 32, 0, 0, time.UTC),
+			"b": time.Date(1979, 5, 27, 0, 32, 0, 999999000, time.FixedZone("", -7*3600)),
+		}},
+		{"local date and time", "a = 1979-05-27T07:32:00\nb = 1979-05-27\nc = 00:32:00.5", map[string]any{
+			"a": LocalDateTime{Date: LocalDate{1979, 5, 27}, Time: LocalTime{Hour: 7, Minute: 32}},
+			"b": LocalDate{1979, 5, 27},
+			"c": LocalTime{Minute: 32, Nanosecond: 500000000},
+		}},
+		{"arrays", "a = [ 1, 'two', [3], { four = 4 }, ]\nb = [\n  # comment\n  \"x\", # after\n]\nc = []", map[string]any{
+			"a": []any{int64(1), "two", []any{int64(3)}, map[string]any{"four": int64(4)}},
+			"b": []any{"x"},
+			"c": []any{},
+		}},
+		{"keys", "bare_key-1 = 1\n\"quoted key\" = 2\n'' = 3\n1234 = 4\nsite.\"google.com\" . x = 5", map[string]any{
+			"bare_key-1": int64(1), "quoted key": int64(2), "": int64(3), "1234": int64(4),
+			"site": map[string]any{"google.com": map[string]any{"x": int64(5)}},
+		}},
+		{"inline tables", `p = { name = { first = "Tom" }, point.x = 1, point.y = 2 }`, map[string]any{
+			"p": map[string]any{"name": map[string]any{"first": "Tom"}, "point": map[string]any{"x": int64(1), "y": int64(2)}},
+		}},
+		{"tables", "[a.b.c]\nx = 1\n[a]\ny = 2\n[a.b.d]\n[ g . 'h' ]", map[string]any{
+			"a": map[string]any{"b": map[string]any{"c": map[string]any{"x": int64(1)}, "d": map[string]any{}}, "y": int64(2)},
+			"g": map[string]any{"h": map[string]any{}},
+		}},
+		{"headers under dotted tables", "[fruit]\napple.color = \"red\"\n[fruit.apple.texture]\nsmooth = true", map[string]any{
+			"fruit": map[string]any{"apple": map[string]any{"color": "red", "texture": map[string]any{"smooth": true}}},
+		}},
+		{"arrays of tables", "[[fruit]]\nname = \"apple\"\n[fruit.physical]\ncolor = \"red\"\n[[fruit.variety]]\nname = \"delicious\"\n[[fruit]]\nname = \"banana\"", map[string]any{
+			"fruit": []any{
+				map[string]any{"name": "apple", "physical": map[string]any{"color": "red"}, "variety": []any{map[string]any{"name": "delicious"}}},
+				map[string]any{"name": "banana"},
+			},
+		}},
+		{"comments and blank lines", "\ufeff# top\r\n\r\n  a = 1 # trailing\n\t[t] # header\n", map[string]any{"a": int64(1), "t": map[string]any{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := decode(t, tt.src)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("got  %#v\nwant %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeSpecialFloats(t *testing.T) {
+	got := decode(t, "a = inf\nb = -inf\nc = nan\nd = +nan")
+	if !math.IsInf(got["a"].(float64), 1) || !math.IsInf(got["b"].(float64), -1) {
+		t.Fatalf("inf = %v, %v", got["a"], got["b"])
+	}
+	if !math.IsNaN(got["c"].(float64)) || !math.IsNaN(got["d"].(float64)) {
+		t.Fatalf("nan = %v, %v", got["c"], got["d"])
+	}
+}
+
+func TestDecodeRejectsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{"bare value", "a = hello", "line 1: expected value"},
+		{"missing value", "a =", "expected value"},
+		{"two keys on a line", "a = 1 b = 2", "expected newline"},
+		{"duplicate key", "a = 1\na = 2", "line 2: duplicate key a"},
+		{"duplicate table", "[a]\n[a]", "line 2: table a is already defined"},
+		{"table over dotted key table", "[fruit]\napple.color = 'red'\n[fruit.apple]", "table fruit.apple is already defined"},
+		{"dotted key into header table", "[a.b.c]\nz = 9\n[a]\nb.c.t = 1", "line 4: cannot add keys to table b"},
+		{"extend inline table", "a = {}\n[a.b]", "cannot extend inline table a"},
+		{"dotted key into inline table", "a = { b = 1 }\na.c = 2", "cannot add keys to inline table a"},
+		{"extend static array", "a = []\n[[a]]", "key a is already defined as array"},
+		{"table over array of tables", "[[a]]\n[a]", "key a is already defined as array"},
+		{"inline table newline", "a = { b = 1,\n c = 2 }", "expected key"},
+		{"inline table trailing comma", "a = { b = 1, }", "trailing comma"},
+		{"unterminated array", "a = [1, 2", "unterminated array"},
+		{"unterminated string", "a = \"abc\nb = 1", "unterminated string"},
+		{"invalid escape", `a = "\x41"`, `invalid escape sequence \x`},
+		{"surrogate escape", `a = "\uD800"`, "invalid unicode scalar value"},
+		{"control character", "a = \"a\x01b\"", "control character"},
+		{"control character in comment", "# a\x7fb\na = 1", "control character"},
+		{"bare carriage return", "a = 1\rb = 2", "bare carriage return"},
+		{"invalid utf-8", "a = \"\xff\"", "invalid UTF-8"},
+		{"multi-line key", `"""a""" = 1`, "multi-line strings cannot be keys"},
+		{"too many quotes", `a = """x""""""`, "too many quotes"},
+		{"leading zero", "a = 012", "invalid number"},
+		{"double underscore", "a = 1__2", "invalid number"},
+		{"trailing underscore", "a = 1_", "invalid number"},
+		{"signed hex", "a = +0x1", "invalid number"},
+		{"uppercase prefix", "a = 0XFF", "invalid number"},
+		{"integer overflow", "a = 9223372036854775808", "out of range"},
+		{"float without fraction", "a = 1.", "invalid float"},
+		{"float without integer", "a = .5", "expected value"},
+		{"float exponent without digits", "a = 1e", "invalid float"},
+		{"invalid month", "a = 2024-13-01", "invalid date"},
+		{"invalid leap day", "a = 2023-02-29", "invalid date"},
+		{"invalid hour", "a = 24:00:00", "invalid time"},
+		{"time without seconds", "a = 07:32", "invalid time"},
+		{"invalid offset", "a = 1979-05-27T07:32:00+25:00", "invalid datetime"},
+		{"array of tables with space", "[ [a]]", "expected key"},
+		{"empty header", "[]", "expected key"},
+		{"text after header", "[a] b = 1", "expected newline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Decode([]byte(tt.src))
+			if err == nil {
+				t.Fatalf("Decode(%q) succeeded, want error containing %q", tt.src, tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Decode(%q) error = %q, want it to contain %q", tt.src, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeKeepsOrderAndSpans(t *testing.T) {
+	doc, err := Decode([]byte("z = 1\n[task.b]\ncmds = [\n  \"one\",\n    \"two\",\n]\n[task.a]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := doc.Keys(); !reflect.DeepEqual(got, []string{"z", "task"}) {
+		t.Fatalf("keys = %v", got)
+	}
+	v, _ := doc.Get("task")
+	tasks := v.(*Table)
+	if got := tasks.Keys(); !reflect.DeepEqual(got, []string{"b", "a"}) {
+		t.Fatalf("task keys = %v", got)
+	}
+	v, _ = tasks.Get("b")
+	b := v.(*Table)
+	if b.Pos != (Position{Line: 2, Col: 1}) {
+		t.Fatalf("table pos = %v", b.Pos)
+	}
+	if got := b.KeySpan("cmds"); got != (Span{Start: Position{Line: 3, Col: 1}, End: Position{Line: 3, Col: 5}}) {
+		t.Fatalf("key span = %v", got)
+	}
+	if got := tasks.ValueSpan("b"); got != (Span{Start: Position{Line: 2, Col: 1}, End: Position{Line: 2, Col: 9}}) {
+		t.Fatalf("header span = %v", got)
+	}
+	v, _ = b.Get("cmds")
+	cmds := v.(*Array)
+	if cmds.Spans[1] != (Span{Start: Position{Line: 5, Col: 5}, End: Position{Line: 5, Col: 10}}) {
+		t.Fatalf("item span = %v", cmds.Spans[1])
+	}
+}
+
+func TestQuoteRoundTrips(t *testing.T) {
+	for _, s := range []string{"", "plain", `say "hi"`, `C:\path`, "tab\tnew\nline", "bell\x07", "ünïcode ✓"} {
+		got := decode(t, "s = "+Quote(s))["s"]
+		if got != s {
+			t.Fatalf("Quote(%q) decoded to %q", s, got)
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package toml
+
+import (
+	"strings"
+)
+
+// Document is the concrete syntax of a TOML file: every statement, comment
+// and blank line in source order, for tools that rewrite a file without
+// losing its layout.
+type Document struct {
+	Nodes []*Node
+}
+
+type NodeKind int
+
+const (
+	BlankNode NodeKind = iota
+	CommentNode
+	TableNode
+	ArrayTableNode
+	KeyValueNode
+)
+
+// Node is one line of a document, or several when a value spans lines.
+// Comment holds an own-line comment or the comment trailing a statement,
+// including the leading '#'.
+type Node struct {
+	Kind    NodeKind
+	Pos     Position
+	Key     []string
+	Value   *Value
+	Comment string
+}
+
+type ValueKind int
+
+const (
+	ScalarValue ValueKind = iota
+	ArrayValue
+	InlineTableValue
+)
+
+// Value is a value as written. Scalars keep their source text in Raw and
+// the decoded value in Data.
+type Value struct {
+	Kind ValueKind
+	Pos  Position
+	Raw  string
+	Data any
+
+	Items       []*ArrayItem
+	OpenComment string
+	EndComments []string
+
+	Entries []*Entry
+}
+
+// ArrayItem is an array element with the own-line comments above it and
+// the comment that follows it on the same line.
+type ArrayItem struct {
+	Comments []string
+	Value    *Value
+	Comment  string
+}
+
+type Entry struct {
+	Key   []string
+	Value *Value
+}
+
+// ParseDocument parses data into a Document. The data must be valid TOML;
+// errors are the ones Decode reports.
+func ParseDocument(data []byte) (*Document, error) {
+	if _, err := Decode(data); err != nil {
+		return nil, err
+	}
+	p := &parser{src: strings.TrimPrefix(string(data), "\uFEFF"), line: 1, col: 1}
+	doc := &Document{}
+	for {
+		p.skipWS()
+		if p.eof() {
+			return doc, nil
+		}
+		node := &Node{Pos: p.pos()}
+		switch p.peek() {
+		case '\n', '\r':
+			node.Kind = BlankNode
+		case '#':
+			node.Kind = CommentNode
+		case '[':
+			node.Kind = TableNode
+			p.advance(1)
+			if p.peek() == '[' {
+				node.Kind = ArrayTableNode
+				p.advance(1)
+			}
+			parts, _, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			node.Key = parts
+			p.advance(1)
+			if node.Kind == ArrayTableNode {
+				p.advance(1)
+			}
+		default:
+			node.Kind = KeyValueNode
+			parts, _, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			node.Key = parts
+			p.advance(1)
+			p.skipWS()
+			if node.Value, err = p.docValue(); err != nil {
+				return nil, err
+			}
+		}
+		p.skipWS()
+		if p.peek() == '#' {
+			text, err := p.commentText()
+			if err != nil {
+				return nil, err
+			}
+			node.Comment = text
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+		doc.Nodes = append(doc.Nodes, node)
+	}
+}
+
+func (p *parser) commentText() (string, error) {
+	start := p.off
+	if err := p.comment(); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(p.src[start:p.off], " \t"), nil
+}
+
+func (p *parser) docValue() (*Value, error) {
+	pos := p.pos()
+	switch p.peek() {
+	case '[':
+		return p.docArray()
+	case '{':
+		return p.docInlineTable()
+	}
+	start := p.off
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	return &Value{Kind: ScalarValue, Pos: pos, Raw: p.src[start:p.off], Data: v}, nil
+}
+
+// docComments skips blank lines inside an array and returns the comments
+// found on lines of their own.
+func (p *parser) docComments() ([]string, error) {
+	var comments []string
+	for {
+		p.skipWS()
+		switch p.peek() {
+		case '#':
+			text, err := p.commentText()
+			if err != nil {
+				return nil, err
+			}
+			comments = append(comments, text)
+		case '\n', '\r':
+			if err := p.newline(); err != nil {
+				return nil, err
+			}
+		default:
+			return comments, nil
+		}
+	}
+}
+
+func (p *parser) docArray() (*Value, error) {
+	val := &Value{Kind: ArrayValue, Pos: p.pos()}
+	p.advance(1)
+	p.skipWS()
+	if p.peek() == '#' {
+		text, err := p.commentText()
+		if err != nil {
+			return nil, err
+		}
+		val.OpenComment = text
+	}
+
+	var pending []string
+	for {
+		comments, err := p.docComments()
+		if err != nil {
+			return nil, err
+		}
+		comments = append(pending, comments...)
+		pending = nil
+		if p.peek() == ']' {
+			p.advance(1)
+			val.EndComments = comments
+			return val, nil
+		}
+
+		item := &ArrayItem{Comments: comments}
+		if item.Value, err = p.docValue(); err != nil {
+			return nil, err
+		}
+		val.Items = append(val.Items, item)
+
+		p.skipWS()
+		if p.peek() == '#' {
+			if item.Comment, err = p.commentText(); err != nil {
+				return nil, err
+			}
+		}
+		if p.peek() == '\n' || p.peek() == '\r' {
+			// Comments between an item and its comma stay with the item;
+			// after the last item they belong to the end of the array.
+			more, err := p.docComments()
+			if err != nil {
+				return nil, err
+			}
+			if p.peek() == ',' {
+				item.Comments = append(item.Comments, more...)
+			} else {
+				pending = more
+			}
+		}
+		if p.peek() == ',' {
+			p.advance(1)
+			p.skipWS()
+			if p.peek() == '#' {
+				text, err := p.commentText()
+				if err != nil {
+					return nil, err
+				}
+				if item.Comment == "" {
+					item.Comment = text
+				} else {
+					item.Comments = append(item.Comments, text)
+				}
+			}
+		}
+	}
+}
+
+func (p *parser) docInlineTable() (*Value, error) {
+	val := &Value{Kind: InlineTableValue, Pos: p.pos()}
+	p.advance(1)
+	p.skipWS()
+	if p.peek() == '}' {
+		p.advance(1)
+		return val, nil
+	}
+	for {
+		parts, _, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.advance(1)
+		p.skipWS()
+		v, err := p.docValue()
+		if err != nil {
+			return nil, err
+		}
+		val.Entries = append(val.Entries, &Entry{Key: parts, Value: v})
+		p.skipWS()
+		c := p.peek()
+		p.advance(1)
+		if c == '}' {
+			return val, nil
+		}
+		p.skipWS()
+	}
+}
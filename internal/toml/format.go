@@ -0,0 +1,169 @@
+package toml
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// Format renders the document in canonical layout. Comments, key order and
+// blank lines (collapsed to one) are kept; keys and strings are re-quoted,
+// indentation and spacing are normalized, each table header is set off by
+// a blank line, and arrays that hold comments or do not fit in width
+// columns are written one item per line.
+func (d *Document) Format(width int) []byte {
+	var b strings.Builder
+	for _, n := range d.layout() {
+		switch n.Kind {
+		case BlankNode:
+			b.WriteString("\n")
+			continue
+		case CommentNode:
+			b.WriteString(n.Comment)
+		case TableNode:
+			b.WriteString("[" + joinKey(n.Key) + "]")
+		case ArrayTableNode:
+			b.WriteString("[[" + joinKey(n.Key) + "]]")
+		case KeyValueNode:
+			prefix := joinKey(n.Key) + " = "
+			b.WriteString(prefix)
+			b.WriteString(formatValue(n.Value, "", utf8.RuneCountInString(prefix), width))
+		}
+		if n.Kind != CommentNode && n.Comment != "" {
+			b.WriteString(" " + n.Comment)
+		}
+		b.WriteString("\n")
+	}
+	return []byte(b.String())
+}
+
+// layout drops leading, trailing and repeated blank lines and separates a
+// table header, together with the comments right above it, from whatever
+// precedes it.
+func (d *Document) layout() []*Node {
+	var out []*Node
+	for _, n := range d.Nodes {
+		if n.Kind == BlankNode {
+			if len(out) > 0 && out[len(out)-1].Kind != BlankNode {
+				out = append(out, n)
+			}
+			continue
+		}
+		if n.Kind == TableNode || n.Kind == ArrayTableNode {
+			i := len(out)
+			for i > 0 && out[i-1].Kind == CommentNode {
+				i--
+			}
+			if i > 0 && out[i-1].Kind != BlankNode {
+				out = append(out[:i], append([]*Node{{Kind: BlankNode}}, out[i:]...)...)
+			}
+		}
+		out = append(out, n)
+	}
+	for len(out) > 0 && out[len(out)-1].Kind == BlankNode {
+		out = out[:len(out)-1]
+	}
+	return out
+}
+
+// formatValue renders v starting at column col of a line indented by
+// indent.
+func formatValue(v *Value, indent string, col, width int) string {
+	if s, ok := flat(v); ok && col+utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	switch v.Kind {
+	case ScalarValue:
+		return formatScalar(v)
+	case InlineTableValue:
+		parts := make([]string, 0, len(v.Entries))
+		col += 2
+		for _, e := range v.Entries {
+			prefix := joinKey(e.Key) + " = "
+			part := prefix + formatValue(e.Value, indent, col+utf8.RuneCountInString(prefix), width)
+			parts = append(parts, part)
+			if i := strings.LastIndexByte(part, '\n'); i >= 0 {
+				col = utf8.RuneCountInString(part[i+1:]) + 2
+			} else {
+				col += utf8.RuneCountInString(part) + 2
+			}
+		}
+		return "{ " + strings.Join(parts, ", ") + " }"
+	}
+
+	inner := indent + "  "
+	var b strings.Builder
+	b.WriteString("[")
+	if v.OpenComment != "" {
+		b.WriteString(" " + v.OpenComment)
+	}
+	b.WriteString("\n")
+	for _, item := range v.Items {
+		for _, c := range item.Comments {
+			b.WriteString(inner + c + "\n")
+		}
+		b.WriteString(inner)
+		b.WriteString(formatValue(item.Value, inner, len(inner), width-1))
+		b.WriteString(",")
+		if item.Comment != "" {
+			b.WriteString(" " + item.Comment)
+		}
+		b.WriteString("\n")
+	}
+	for _, c := range v.EndComments {
+		b.WriteString(inner + c + "\n")
+	}
+	b.WriteString(indent + "]")
+	return b.String()
+}
+
+// flat renders v on a single line, which is not possible when it holds
+// comments or multi-line strings.
+func flat(v *Value) (string, bool) {
+	switch v.Kind {
+	case ScalarValue:
+		s := formatScalar(v)
+		return s, !strings.Contains(s, "\n")
+	case InlineTableValue:
+		if len(v.Entries) == 0 {
+			return "{}", true
+		}
+		parts := make([]string, 0, len(v.Entries))
+		for _, e := range v.Entries {
+			s, ok := flat(e.Value)
+			if !ok {
+				return "", false
+			}
+			parts = append(parts, joinKey(e.Key)+" = "+s)
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", true
+	}
+	if v.OpenComment != "" || len(v.EndComments) > 0 {
+		return "", false
+	}
+	parts := make([]string, 0, len(v.Items))
+	for _, item := range v.Items {
+		if len(item.Comments) > 0 || item.Comment != "" {
+			return "", false
+		}
+		s, ok := flat(item.Value)
+		if !ok {
+			return "", false
+		}
+		parts = append(parts, s)
+	}
+	return "[" + strings.Join(parts, ", ") + "]", true
+}
+
+// formatScalar writes strings as basic strings. Multi-line strings, and
+// literal strings that would need escapes, are kept as written, as are
+// numbers, booleans and dates.
+func formatScalar(v *Value) string {
+	s, ok := v.Data.(string)
+	switch {
+	case !ok, strings.HasPrefix(v.Raw, `"""`), strings.HasPrefix(v.Raw, "'''"):
+		return v.Raw
+	case v.Raw[0] == '\'' && strings.ContainsAny(s, `\"`):
+		return v.Raw
+	}
+	return Quote(s)
+}
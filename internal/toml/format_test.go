@@ -0,0 +1,57 @@
+package toml
+
+import "testing"
+
+func TestFormatKeepsCommentsAndNormalizesLayout(t *testing.T) {
+	src := "\n\n# Build file\ndefault   =   'build'   # the default\n\n\n\n[vars]\n  'APP' = \"rem\"\nWIN = 'C:\\bin'\n# about build\n[ task . build ]\ncmds = [ # steps\n  # first\n  \"go generate ./...\",   # gen\n\n  'go build ./...'\n  # done\n]\nenv = {GOOS='linux',  GOARCH = \"amd64\"}\nnotes = '''\nkept   as is'''\n[[ \"x\".y ]]\nn = 0x1F\n\n"
+	want := `# Build file
+default = "build" # the default
+
+[vars]
+APP = "rem"
+WIN = 'C:\bin'
+
+# about build
+[task.build]
+cmds = [ # steps
+  # first
+  "go generate ./...", # gen
+  "go build ./...",
+  # done
+]
+env = { GOOS = "linux", GOARCH = "amd64" }
+notes = '''
+kept   as is'''
+
+[[x.y]]
+n = 0x1F
+`
+	doc, err := ParseDocument([]byte(src))
+	if err != nil {
+		t.Fatalf("ParseDocument() error: %v", err)
+	}
+	got := string(doc.Format(80))
+	if got != want {
+		t.Fatalf("Format() =\n%s\nwant\n%s", got, want)
+	}
+
+	again, err := ParseDocument([]byte(got))
+	if err != nil {
+		t.Fatalf("ParseDocument(formatted) error: %v", err)
+	}
+	if string(again.Format(80)) != got {
+		t.Fatalf("Format() is not idempotent:\n%s", again.Format(80))
+	}
+}
+
+func TestFormatWrapsLongArrays(t *testing.T) {
+	src := "short = [\n  \"a\",\n  \"b\",\n]\nlong = [\"aaaaaaaaaa\", \"bbbbbbbbbb\", [\"cccccccccc\", \"dddddddddd\"]]\n"
+	want := "short = [\"a\", \"b\"]\nlong = [\n  \"aaaaaaaaaa\",\n  \"bbbbbbbbbb\",\n  [\n    \"cccccccccc\",\n    \"dddddddddd\",\n  ],\n]\n"
+	doc, err := ParseDocument([]byte(src))
+	if err != nil {
+		t.Fatalf("ParseDocument() error: %v", err)
+	}
+	if got := string(doc.Format(30)); got != want {
+		t.Fatalf("Format() =\n%s\nwant\n%s", got, want)
+	}
+}
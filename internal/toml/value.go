@@ -0,0 +1,239 @@
+// Package toml decodes TOML 1.0 documents into ordered tables that keep
+// the source position of every key and array item.
+package toml
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Position is a 1-based line and column; columns count runes.
+type Position struct {
+	Line int
+	Col  int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Before reports whether p comes earlier in the source than q.
+func (p Position) Before(q Position) bool {
+	return p.Line < q.Line || (p.Line == q.Line && p.Col < q.Col)
+}
+
+// Span is the source range of a key or value; End is exclusive.
+type Span struct {
+	Start Position
+	End   Position
+}
+
+type tableKind int
+
+const (
+	// kindImplicit tables were created as parents of a [a.b.c] header
+	// and may still be defined by a header of their own.
+	kindImplicit tableKind = iota
+	kindHeader
+	kindDotted
+	kindInline
+)
+
+// Table is a TOML table. Keys keep their definition order. Values are
+// string, int64, float64, bool, time.Time (offset date-times), LocalDate,
+// LocalTime, LocalDateTime, *Array or *Table.
+type Table struct {
+	Pos Position
+
+	kind    tableKind
+	keys    []string
+	values  map[string]any
+	keySpan map[string]Span
+	valSpan map[string]Span
+}
+
+func newTable(pos Position, kind tableKind) *Table {
+	return &Table{
+		Pos:     pos,
+		kind:    kind,
+		values:  make(map[string]any),
+		keySpan: make(map[string]Span),
+		valSpan: make(map[string]Span),
+	}
+}
+
+func (t *Table) set(key string, v any, keySpan, valSpan Span) {
+	t.keys = append(t.keys, key)
+	t.values[key] = v
+	t.keySpan[key] = keySpan
+	t.valSpan[key] = valSpan
+}
+
+// Keys returns the keys of the table in the order they were defined.
+func (t *Table) Keys() []string {
+	return t.keys
+}
+
+func (t *Table) Get(key string) (any, bool) {
+	v, ok := t.values[key]
+	return v, ok
+}
+
+// KeySpan returns where key was written, or the zero Span when the table
+// has no such key. For a table defined by a header it is the last part of
+// the header's key.
+func (t *Table) KeySpan(key string) Span {
+	return t.keySpan[key]
+}
+
+// ValueSpan returns where the value of key was written; for a table
+// defined by a header it is the whole header.
+func (t *Table) ValueSpan(key string) Span {
+	return t.valSpan[key]
+}
+
+func (t *Table) Len() int {
+	return len(t.keys)
+}
+
+// Implicit reports whether the table only exists as the parent of another
+// table header, as `a` does after `[a.b]`.
+func (t *Table) Implicit() bool {
+	return t.kind == kindImplicit
+}
+
+// Inline reports whether the table was written as `{ ... }`.
+func (t *Table) Inline() bool {
+	return t.kind == kindInline
+}
+
+// Array is a TOML array; Spans[i] is where Items[i] was written.
+type Array struct {
+	Items []any
+	Spans []Span
+
+	tables bool
+}
+
+func (a *Array) append(v any, span Span) {
+	a.Items = append(a.Items, v)
+	a.Spans = append(a.Spans, span)
+}
+
+// Tables reports whether the array was built from [[header]] sections.
+func (a *Array) Tables() bool {
+	return a.tables
+}
+
+type LocalDate struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+func (d LocalDate) String() string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
+
+type LocalTime struct {
+	Hour       int
+	Minute     int
+	Second     int
+	Nanosecond int
+}
+
+func (t LocalTime) String() string {
+	s := fmt.Sprintf("%02d:%02d:%02d", t.Hour, t.Minute, t.Second)
+	if t.Nanosecond != 0 {
+		s += strings.TrimRight(fmt.Sprintf(".%09d", t.Nanosecond), "0")
+	}
+	return s
+}
+
+type LocalDateTime struct {
+	Date LocalDate
+	Time LocalTime
+}
+
+func (dt LocalDateTime) String() string {
+	return dt.Date.String() + "T" + dt.Time.String()
+}
+
+// TypeName names the TOML type of a decoded value for error messages.
+func TypeName(v any) string {
+	switch v := v.(type) {
+	case string:
+		return "string"
+	case int64:
+		return "integer"
+	case float64:
+		return "float"
+	case bool:
+		return "boolean"
+	case time.Time, LocalDateTime:
+		return "datetime"
+	case LocalDate:
+		return "date"
+	case LocalTime:
+		return "time"
+	case *Array:
+		return "array"
+	case *Table:
+		if v.Inline() {
+			return "inline table"
+		}
+		return "table"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// Quote returns s as a TOML basic string.
+func Quote(s string) string {
+	var b strings.Builder
+	b.Grow(len(s) + 2)
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+				continue
+			}
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// IsBareKey reports whether key can be written without quotes.
+func IsBareKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !isBareKeyChar(key[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isBareKeyChar(c byte) bool {
+	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}